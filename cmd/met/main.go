@@ -2,21 +2,39 @@ package main
 
 import (
 	run "github.com/Wesenheit/Skaldenmet/internal/cli"
+	"github.com/Wesenheit/Skaldenmet/internal/config"
 	"github.com/Wesenheit/Skaldenmet/internal/daemon"
 	"github.com/Wesenheit/Skaldenmet/internal/display"
+	"github.com/Wesenheit/Skaldenmet/internal/remote"
+	"github.com/Wesenheit/Skaldenmet/internal/sockets"
 
 	"github.com/spf13/cobra"
 )
 
 func main() {
 	rootCmd := &cobra.Command{Use: "met"}
+	rootCmd.PersistentFlags().StringVar(&sockets.Dir, "socket-dir", "", "directory with the daemon sockets (default $"+sockets.EnvDir+", then the user or system daemon)")
+	rootCmd.PersistentFlags().StringVar(&remote.Host, "host", "", "query a remote daemon over TLS, host[:port] (default $"+remote.EnvHost+")")
+	rootCmd.PersistentFlags().StringVar(&remote.Client.Cert, "tls-cert", "", "client certificate for --host (default $"+remote.EnvCert+")")
+	rootCmd.PersistentFlags().StringVar(&remote.Client.Key, "tls-key", "", "key of the client certificate (default $"+remote.EnvKey+")")
+	rootCmd.PersistentFlags().StringVar(&remote.Client.CA, "tls-ca", "", "CA the daemon certificate is signed by (default $"+remote.EnvCA+")")
 
 	var runCobra = run.RunCmd
 	var daemonCobra = daemon.DaemonCmd
 	var listCobra = display.ListCmd
+	var seffCobra = display.SeffCmd
+	var showCobra = display.ShowCmd
+	var watchCobra = display.WatchCmd
+	var cancelCobra = run.CancelCmd
+	var configCobra = config.ConfigCmd
 	rootCmd.AddCommand(runCobra)
 	rootCmd.AddCommand(daemonCobra)
 	rootCmd.AddCommand(listCobra)
+	rootCmd.AddCommand(seffCobra)
+	rootCmd.AddCommand(showCobra)
+	rootCmd.AddCommand(watchCobra)
+	rootCmd.AddCommand(cancelCobra)
+	rootCmd.AddCommand(configCobra)
 
 	rootCmd.Execute()
 }
@@ -0,0 +1,28 @@
+#!/usr/bin/env python3
+"""External collector reporting the number of threads of every process.
+
+Run once per interval, or with --persistent as a long-lived child answering
+one line per request.
+"""
+import json
+import sys
+
+
+def answer(request):
+    records = []
+    for pid in request["targets"]:
+        try:
+            with open(f"/proc/{pid}/status") as status:
+                for line in status:
+                    if line.startswith("Threads:"):
+                        records.append({"pid": int(pid), "name": "threads", "aggregation": "max", "value": int(line.split()[1])})
+        except OSError:
+            continue
+    return records
+
+
+if "--persistent" in sys.argv:
+    for line in sys.stdin:
+        print(json.dumps(answer(json.loads(line))), flush=True)
+else:
+    print(json.dumps(answer(json.load(sys.stdin))))
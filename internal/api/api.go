@@ -0,0 +1,303 @@
+package api
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Wesenheit/Skaldenmet/internal/auth"
+	"github.com/Wesenheit/Skaldenmet/internal/comm"
+	"github.com/Wesenheit/Skaldenmet/internal/events"
+	"github.com/Wesenheit/Skaldenmet/internal/metrics"
+	"github.com/Wesenheit/Skaldenmet/internal/proces"
+	"github.com/Wesenheit/Skaldenmet/internal/storage"
+
+	"github.com/spf13/viper"
+)
+
+//go:embed openapi.yaml
+var openAPI []byte
+
+// maxBodySize limits the size of submitted job descriptions
+const maxBodySize = 1 << 20
+
+// Server exposes storage and job control over HTTP, either on a unix socket
+// ("unix:/path") or on a TCP address which should be bound to localhost.
+// Clients on a unix socket are identified by their credentials, clients over
+// TCP cannot be and are anonymous, they may read but not control jobs.
+type Server struct {
+	storage    storage.Storage
+	controller comm.Controller
+	policy     *auth.Policy
+	control    bool
+	listener   net.Listener
+	server     *http.Server
+}
+
+// NewServer returns nil when the http section is not configured. Submit and
+// cancel are only served with http.control enabled, to clients on a unix
+// socket.
+func NewServer(v *viper.Viper, provider storage.Storage, controller comm.Controller, policy *auth.Policy) (*Server, error) {
+	address := v.GetString("http.listen")
+	if address == "" {
+		return nil, nil
+	}
+
+	var listener net.Listener
+	var err error
+	if socketPath, isUnix := strings.CutPrefix(address, "unix:"); isUnix {
+		if _, err := os.Stat(socketPath); err == nil {
+			if err := os.Remove(socketPath); err != nil {
+				return nil, err
+			}
+		}
+		listener, err = net.Listen("unix", socketPath)
+		if err == nil {
+			os.Chmod(socketPath, 0660)
+		}
+	} else {
+		listener, err = net.Listen("tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+
+	s := &Server{
+		storage:    provider,
+		controller: controller,
+		policy:     policy,
+		control:    v.GetBool("http.control"),
+		listener:   listener,
+	}
+	s.server = &http.Server{
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ConnContext:       s.identify,
+	}
+	log.Printf("HTTP API listening on %s", address)
+	return s, nil
+}
+
+func (s *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/openapi.yaml", s.handleOpenAPI)
+	mux.HandleFunc("GET /v1/jobs", s.handleJobs)
+	mux.HandleFunc("POST /v1/jobs", s.handleSubmit)
+	mux.HandleFunc("GET /v1/jobs/{job}", s.handleJob)
+	mux.HandleFunc("POST /v1/jobs/{job}/cancel", s.handleCancel)
+	mux.HandleFunc("GET /v1/jobs/{job}/summary", s.handleJobSummary)
+	mux.HandleFunc("GET /v1/jobs/{job}/processes", s.handleProcesses)
+	mux.HandleFunc("GET /v1/jobs/{job}/series", s.handleSeries)
+	mux.HandleFunc("GET /v1/summaries/{kind}", s.handleSummaries)
+	return mux
+}
+
+type peerKey struct{}
+
+type peerResult struct {
+	peer auth.Peer
+	err  error
+}
+
+func (s *Server) identify(ctx context.Context, conn net.Conn) context.Context {
+	var result peerResult
+	if _, isUnix := conn.(*net.UnixConn); isUnix {
+		result.peer, result.err = s.policy.Identify(conn)
+	} else {
+		result.peer = s.policy.Anonymous()
+	}
+	return context.WithValue(ctx, peerKey{}, result)
+}
+
+// peer returns the identity of the client, refusing clients without one
+func (s *Server) peer(w http.ResponseWriter, r *http.Request) (auth.Peer, bool) {
+	result, ok := r.Context().Value(peerKey{}).(peerResult)
+	if !ok || result.err != nil {
+		writeError(w, http.StatusForbidden, "unknown client: %v", result.err)
+		return auth.Peer{}, false
+	}
+	return result.peer, true
+}
+
+func (s *Server) Serve() error {
+	err := s.server.Serve(s.listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) Finalize(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("Failed to encode and send: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+// job resolves the {job} path segment, a PGID of a registered job visible
+// to the client
+func (s *Server) job(w http.ResponseWriter, r *http.Request) (proces.Process, bool) {
+	peer, ok := s.peer(w, r)
+	if !ok {
+		return proces.Process{}, false
+	}
+	pgid, err := strconv.ParseInt(r.PathValue("job"), 10, 32)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid job %q", r.PathValue("job"))
+		return proces.Process{}, false
+	}
+	job, ok := s.storage.GetJobsSnapshot()[int32(pgid)]
+	if !ok || !s.policy.CanSee(peer, job) {
+		writeError(w, http.StatusNotFound, "job %d not found", pgid)
+		return proces.Process{}, false
+	}
+	return job, true
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(openAPI)
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	peer, ok := s.peer(w, r)
+	if !ok {
+		return
+	}
+	jobs := s.storage.GetJobsSnapshot()
+	writeJSON(w, http.StatusOK, auth.Visible(s.policy, peer, jobs, jobs))
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	if job, ok := s.job(w, r); ok {
+		writeJSON(w, http.StatusOK, job)
+	}
+}
+
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	if !s.control {
+		writeError(w, http.StatusForbidden, "job control is disabled, set http.control to enable it")
+		return
+	}
+	peer, ok := s.peer(w, r)
+	if !ok {
+		return
+	}
+	if !s.policy.Local(peer) {
+		writeError(w, http.StatusForbidden, "jobs can only be submitted over a unix socket")
+		return
+	}
+	var spec proces.Spec
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&spec); err != nil {
+		writeError(w, http.StatusBadRequest, "malformed job: %v", err)
+		return
+	}
+	if err := proces.CheckName(spec.Name); err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if spec.WorkDir != "" && !strings.HasPrefix(spec.WorkDir, "/") {
+		writeError(w, http.StatusBadRequest, "work_dir must be an absolute path")
+		return
+	}
+	job, err := s.controller.Submit(spec, peer)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "%v", err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, job)
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if !s.control {
+		writeError(w, http.StatusForbidden, "job control is disabled, set http.control to enable it")
+		return
+	}
+	job, ok := s.job(w, r)
+	if !ok {
+		return
+	}
+	peer, _ := s.peer(w, r)
+	if !s.policy.Local(peer) {
+		writeError(w, http.StatusForbidden, "jobs can only be cancelled over a unix socket")
+		return
+	}
+	if !s.policy.CanControl(peer, job) {
+		writeError(w, http.StatusForbidden, "job %d is owned by %s", job.PGID, job.User)
+		return
+	}
+	if err := s.controller.Cancel(job.PGID); err != nil {
+		writeError(w, http.StatusConflict, "%v", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handleJobSummary(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(w, r)
+	if !ok {
+		return
+	}
+	summary := make(map[string]metrics.Summary)
+	for _, kind := range metrics.KindNames() {
+		summaries, _ := s.storage.GetSummaries(kind)
+		summary[kind] = summaries[job.PGID]
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+func (s *Server) handleProcesses(w http.ResponseWriter, r *http.Request) {
+	if job, ok := s.job(w, r); ok {
+		writeJSON(w, http.StatusOK, s.storage.GetProcessSnapshot(job.PGID))
+	}
+}
+
+func (s *Server) handleSeries(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(w, r)
+	if !ok {
+		return
+	}
+	kind := r.URL.Query().Get("kind")
+	samples := []events.Sample{}
+	for _, sample := range events.NewSamples(s.storage.GetSeries(job.PGID)) {
+		if kind == "" || sample.Kind == kind {
+			samples = append(samples, sample)
+		}
+	}
+	writeJSON(w, http.StatusOK, samples)
+}
+
+func (s *Server) handleSummaries(w http.ResponseWriter, r *http.Request) {
+	peer, ok := s.peer(w, r)
+	if !ok {
+		return
+	}
+	jobs := s.storage.GetJobsSnapshot()
+	summaries, ok := s.storage.GetSummaries(r.PathValue("kind"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown summary kind %q", r.PathValue("kind"))
+		return
+	}
+	data := auth.Visible(s.policy, peer, jobs, summaries)
+	writeJSON(w, http.StatusOK, data)
+}
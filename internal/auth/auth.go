@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+
+	"github.com/Wesenheit/Skaldenmet/internal/proces"
+
+	"github.com/shirou/gopsutil/v4/process"
+	"github.com/spf13/viper"
+)
+
+// Peer is the user on the other end of a connection
+type Peer struct {
+	UID   uint32
+	GID   uint32
+	PID   int32
+	User  string
+	Admin bool
+	// Remote peers connect over TLS, they may read jobs but neither register
+	// nor control them
+	Remote bool
+	// Node peers are daemons of the cluster named in auth.nodes, they read
+	// all jobs and filter them for their own users
+	Node bool
+	// Anonymous peers could not be identified, e.g. HTTP clients over TCP.
+	// They see jobs only when listing is not restricted and control nothing.
+	Anonymous bool
+}
+
+// Policy decides what a peer may see and do. Root, the user running the
+// daemon and members of the admin group are administrators.
+type Policy struct {
+	restrictList bool
+	adminGID     string
+	nodes        []string
+}
+
+func NewPolicy(v *viper.Viper) (*Policy, error) {
+	policy := &Policy{
+		restrictList: v.GetBool("auth.restrictList"),
+		nodes:        v.GetStringSlice("auth.nodes"),
+	}
+	if name := v.GetString("auth.adminGroup"); name != "" {
+		group, err := user.LookupGroup(name)
+		if err != nil {
+			return nil, fmt.Errorf("admin group: %w", err)
+		}
+		policy.adminGID = group.Gid
+	}
+	return policy, nil
+}
+
+// Identify reads the credentials of the process connected over a unix socket.
+// Clients of the TLS listener were authenticated by their certificate during
+// the handshake. A common name listed in auth.nodes is a daemon of the
+// cluster, any other is the local user of that name unless that user is an
+// administrator.
+func (p *Policy) Identify(conn net.Conn) (Peer, error) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		certs := tlsConn.ConnectionState().PeerCertificates
+		if len(certs) == 0 {
+			return Peer{}, errors.New("no client certificate")
+		}
+		return p.remote(certs[0].Subject.CommonName), nil
+	}
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return Peer{}, fmt.Errorf("peer credentials need a unix socket, got %s", conn.RemoteAddr().Network())
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return Peer{}, err
+	}
+	var peer Peer
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		peer, credErr = peerCredentials(int(fd))
+	})
+	if err != nil {
+		return Peer{}, err
+	}
+	if credErr != nil {
+		return Peer{}, fmt.Errorf("failed to read peer credentials: %w", credErr)
+	}
+	return p.resolve(peer), nil
+}
+
+// Anonymous is the identity of clients that cannot be authenticated, such as
+// HTTP over TCP
+func (p *Policy) Anonymous() Peer {
+	return Peer{User: "anonymous", Anonymous: true}
+}
+
+// remote identifies a client of the TLS listener by the common name of its
+// certificate. Names without a local account are anonymous, and so are names
+// of administrators: a certificate never grants the rights of root or of the
+// daemon user.
+func (p *Policy) remote(name string) Peer {
+	if slices.Contains(p.nodes, name) {
+		return Peer{User: name, Remote: true, Node: true}
+	}
+	account, err := user.Lookup(name)
+	if err != nil {
+		return Peer{User: name, Remote: true, Anonymous: true}
+	}
+	uid, err := strconv.ParseUint(account.Uid, 10, 32)
+	if err != nil {
+		return Peer{User: name, Remote: true, Anonymous: true}
+	}
+	gid, _ := strconv.ParseUint(account.Gid, 10, 32)
+	peer := p.resolve(Peer{UID: uint32(uid), GID: uint32(gid)})
+	if peer.Admin {
+		return Peer{User: name, Remote: true, Anonymous: true}
+	}
+	peer.Remote = true
+	return peer
+}
+
+func (p *Policy) resolve(peer Peer) Peer {
+	uid := strconv.FormatUint(uint64(peer.UID), 10)
+	peer.User = uid
+	peer.Admin = peer.UID == 0 || peer.UID == uint32(os.Getuid())
+	account, err := user.LookupId(uid)
+	if err != nil {
+		return peer
+	}
+	peer.User = account.Username
+	if p.adminGID != "" && !peer.Admin {
+		groups, err := account.GroupIds()
+		peer.Admin = err == nil && slices.Contains(groups, p.adminGID)
+	}
+	return peer
+}
+
+// Restricts reports whether some jobs are hidden from the peer
+func (p *Policy) Restricts(peer Peer) bool {
+	return p.restrictList && !peer.Admin && !peer.Node
+}
+
+// CanSee reports whether the peer may read data of the job
+func (p *Policy) CanSee(peer Peer, job proces.Process) bool {
+	return !p.Restricts(peer) || (!peer.Anonymous && peer.UID == job.UID)
+}
+
+// CanControl reports whether the peer may act on the job, e.g. cancel it
+func (p *Policy) CanControl(peer Peer, job proces.Process) bool {
+	return p.Local(peer) && (peer.Admin || peer.UID == job.UID)
+}
+
+// Local reports whether the peer was identified by its credentials on a
+// unix socket, only local peers may start and control jobs
+func (p *Policy) Local(peer Peer) bool {
+	return !peer.Remote && !peer.Anonymous
+}
+
+// CheckOwner refuses registration of a process group the peer does not own
+func (p *Policy) CheckOwner(peer Peer, pgid int32) error {
+	if !p.Local(peer) {
+		return errors.New("jobs can only be registered over the local socket")
+	}
+	if peer.Admin {
+		return nil
+	}
+	proc, err := process.NewProcess(pgid)
+	if err != nil {
+		return fmt.Errorf("process group %d does not exist", pgid)
+	}
+	uids, err := proc.Uids()
+	if err != nil || len(uids) == 0 {
+		return fmt.Errorf("failed to read owner of process %d", pgid)
+	}
+	if uids[0] != peer.UID {
+		return fmt.Errorf("process group %d is not owned by %s", pgid, peer.User)
+	}
+	return nil
+}
+
+// Visible keeps entries of jobs the peer may see
+func Visible[T any](p *Policy, peer Peer, jobs map[int32]proces.Process, data map[int32]T) map[int32]T {
+	if !p.Restricts(peer) {
+		return data
+	}
+	visible := make(map[int32]T, len(data))
+	for pgid, value := range data {
+		if job, ok := jobs[pgid]; ok && p.CanSee(peer, job) {
+			visible[pgid] = value
+		}
+	}
+	return visible
+}
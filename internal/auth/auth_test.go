@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"testing"
+
+	"github.com/Wesenheit/Skaldenmet/internal/proces"
+)
+
+type remoteCase struct {
+	name      string
+	common    string
+	anonymous bool
+	node      bool
+}
+
+func TestRemotePeers(t *testing.T) {
+	policy := &Policy{nodes: []string{"head"}}
+	cases := []remoteCase{
+		{"cluster node", "head", false, true},
+		{"unknown name", "no-such-user-skald", true, false},
+		{"root is refused", "root", true, false},
+	}
+	if self, err := user.Current(); err == nil {
+		cases = append(cases, remoteCase{"daemon user is refused", self.Username, true, false})
+	}
+	if account, err := user.Lookup("nobody"); err == nil && account.Uid != strconv.Itoa(os.Getuid()) && account.Uid != "0" {
+		cases = append(cases, remoteCase{"ordinary user", "nobody", false, false})
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			peer := policy.remote(c.common)
+			if !peer.Remote || peer.Admin {
+				t.Errorf("%+v: want a remote peer without admin rights", peer)
+			}
+			if peer.Anonymous != c.anonymous || peer.Node != c.node {
+				t.Errorf("%+v: want anonymous %v and node %v", peer, c.anonymous, c.node)
+			}
+			if peer.User != c.common {
+				t.Errorf("%+v: want user %s", peer, c.common)
+			}
+			if policy.Local(peer) {
+				t.Errorf("%+v: remote peer is local", peer)
+			}
+		})
+	}
+}
+
+func TestPolicyRules(t *testing.T) {
+	job := proces.Process{PGID: 100, UID: 1000}
+	owner := Peer{UID: 1000, User: "owner"}
+	other := Peer{UID: 1001, User: "other"}
+	admin := Peer{UID: 0, User: "root", Admin: true}
+	node := Peer{User: "head", Remote: true, Node: true}
+	remoteOwner := Peer{UID: 1000, User: "owner", Remote: true}
+	anonymous := (&Policy{}).Anonymous()
+
+	cases := []struct {
+		name       string
+		restrict   bool
+		peer       Peer
+		restricts  bool
+		canSee     bool
+		canControl bool
+	}{
+		{"owner", false, owner, false, true, true},
+		{"other user", false, other, false, true, false},
+		{"other user restricted", true, other, true, false, false},
+		{"owner restricted", true, owner, true, true, true},
+		{"admin restricted", true, admin, false, true, true},
+		{"node restricted", true, node, false, true, false},
+		{"remote owner", true, remoteOwner, true, true, false},
+		{"anonymous", false, anonymous, false, true, false},
+		{"anonymous restricted", true, anonymous, true, false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			policy := &Policy{restrictList: c.restrict}
+			if got := policy.Restricts(c.peer); got != c.restricts {
+				t.Errorf("Restricts: got %v, want %v", got, c.restricts)
+			}
+			if got := policy.CanSee(c.peer, job); got != c.canSee {
+				t.Errorf("CanSee: got %v, want %v", got, c.canSee)
+			}
+			if got := policy.CanControl(c.peer, job); got != c.canControl {
+				t.Errorf("CanControl: got %v, want %v", got, c.canControl)
+			}
+			visible := Visible(policy, c.peer, map[int32]proces.Process{job.PGID: job}, map[int32]string{job.PGID: "data", 200: "unknown job"})
+			if _, ok := visible[job.PGID]; ok != c.canSee {
+				t.Errorf("Visible: job kept %v, want %v", ok, c.canSee)
+			}
+		})
+	}
+}
+
+func TestResolveAdmin(t *testing.T) {
+	policy := &Policy{}
+	if peer := policy.resolve(Peer{UID: 0}); !peer.Admin || peer.User != "root" {
+		t.Errorf("%+v: want root to be an administrator", peer)
+	}
+	if peer := policy.resolve(Peer{UID: uint32(os.Getuid())}); !peer.Admin {
+		t.Errorf("%+v: want the daemon user to be an administrator", peer)
+	}
+	if os.Getuid() != 65534 {
+		if peer := policy.resolve(Peer{UID: 65534}); peer.Admin {
+			t.Errorf("%+v: want an ordinary user", peer)
+		}
+	}
+}
+
+func TestCheckOwner(t *testing.T) {
+	policy := &Policy{}
+	pgid := int32(os.Getpid())
+	uid := uint32(os.Getuid())
+	cases := []struct {
+		name string
+		peer Peer
+		ok   bool
+	}{
+		{"owner", Peer{UID: uid, User: "owner"}, true},
+		{"admin", Peer{UID: uid + 1, User: "admin", Admin: true}, true},
+		{"other user", Peer{UID: uid + 1, User: "other"}, false},
+		{"remote owner", Peer{UID: uid, User: "owner", Remote: true}, false},
+		{"anonymous admin", Peer{User: "anonymous", Admin: true, Anonymous: true}, false},
+	}
+	for _, c := range cases {
+		if err := policy.CheckOwner(c.peer, pgid); (err == nil) != c.ok {
+			t.Errorf("%s: got %v, want allowed %v", c.name, err, c.ok)
+		}
+	}
+	if err := policy.CheckOwner(Peer{UID: uid + 1, User: "other"}, 1<<30); err == nil {
+		t.Error("registering a missing process group was allowed")
+	}
+}
@@ -0,0 +1,18 @@
+package auth
+
+import "golang.org/x/sys/unix"
+
+func peerCredentials(fd int) (Peer, error) {
+	cred, err := unix.GetsockoptXucred(fd, unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	if err != nil {
+		return Peer{}, err
+	}
+	peer := Peer{UID: cred.Uid}
+	if cred.Ngroups > 0 {
+		peer.GID = cred.Groups[0]
+	}
+	if pid, err := unix.GetsockoptInt(fd, unix.SOL_LOCAL, unix.LOCAL_PEERPID); err == nil {
+		peer.PID = int32(pid)
+	}
+	return peer, nil
+}
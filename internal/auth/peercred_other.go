@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package auth
+
+import "errors"
+
+func peerCredentials(fd int) (Peer, error) {
+	return Peer{}, errors.New("peer credentials are not supported on this platform")
+}
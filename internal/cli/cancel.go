@@ -0,0 +1,36 @@
+package run
+
+import (
+	"log"
+
+	"github.com/Wesenheit/Skaldenmet/internal/client"
+	"github.com/Wesenheit/Skaldenmet/internal/display"
+
+	"github.com/spf13/cobra"
+)
+
+var CancelCmd = &cobra.Command{
+	Use:   "cancel <job>",
+	Short: "terminate all processes of a running job",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := client.Connect()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer c.Close()
+
+		jobs, err := c.Jobs()
+		if err != nil {
+			log.Fatal(err)
+		}
+		job, err := display.FindJob(jobs, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := c.Cancel(job.PGID); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Cancelled job %s (PGID %d)", job.Name, job.PGID)
+	},
+}
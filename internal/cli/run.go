@@ -1,30 +1,17 @@
 package run
 
 import (
+	"errors"
 	"log"
-	"os"
-	"os/exec"
-	"github.com/Wesenheit/Skaldenmet/internal/comm"
+	"github.com/Wesenheit/Skaldenmet/internal/client"
 	"github.com/Wesenheit/Skaldenmet/internal/proces"
+	"github.com/Wesenheit/Skaldenmet/internal/sockets"
 	"strings"
 	"syscall"
-	"time"
 
 	"github.com/spf13/cobra"
 )
 
-func getLogFiles(name string) (*os.File, *os.File, error) {
-	out_file, err_out := os.Create(name + ".out")
-	if err_out != nil {
-		return nil, nil, err_out
-	}
-	err_file, err_err := os.Create(name + ".err")
-	if err_out != nil {
-		return nil, nil, err_err
-	}
-	return out_file, err_file, nil
-}
-
 var RunCmd = &cobra.Command{
 	Use:   "run",
 	Short: "run the command",
@@ -43,46 +30,53 @@ var RunCmd = &cobra.Command{
 		} else {
 			name = varName
 		}
-		fileOut, fileErr, err := getLogFiles(name)
+		// the connection is opened first, the daemon prepares the directory
+		// the job records its exit status in
+		c, err := client.Dial(sockets.Control(sockets.ClientDir()))
 		if err != nil {
-			log.Print("Failed to create files")
+			log.Fatal(err)
 		}
-
-		Cmd := exec.Command("sh", "-c", userCommand)
-		Cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-		Cmd.Env = os.Environ()
-		Cmd.Stdout = fileOut
-		Cmd.Stderr = fileErr
-
-		err = Cmd.Start()
+		defer c.Close()
+		exits, err := c.ExitDir()
 		if err != nil {
-			log.Printf("failed to execute command: %s", err)
+			log.Printf("The exit code of job %s will not be recorded: %s", name, err)
 		}
-		go func() {
-			defer fileOut.Close()
-			defer fileErr.Close()
-
-			Cmd.Wait()
-		}()
-		pgid, _ := syscall.Getpgid(Cmd.Process.Pid)
-		log.Printf("Started command %s with PPID %d", name, pgid)
-		info := proces.Process{
-			PGID:      int32(pgid),
-			Command:   args[0],
-			StartTime: time.Now(),
-			Name:      name,
+		info, Cmd, err := proces.Launch(proces.Spec{
+			Name:    name,
+			Command: userCommand,
+			Cpus:    varCpus,
+			Memory:  varMem,
+		}, exits, nil)
+		if err != nil {
+			log.Fatal(err)
 		}
+		// the child stays unreaped until the daemon answers, so a job that
+		// ends at once still has a group to register, init reaps it once
+		// we exit
+		pgid := int(info.PGID)
+		log.Printf("Started command %s with PPID %d", name, pgid)
 
-		manager := comm.UnixSocketMonitor{SocketPath: "/tmp/skald.socket"}
-		err = manager.Notify(info)
+		accepted, err := c.Register(info)
 		if err != nil {
-			log.Printf("failed to notify: %s", err)
+			// an unmonitored job is not what the user asked for
+			syscall.Kill(-pgid, syscall.SIGTERM)
+			Cmd.Wait()
+			var daemonErr *client.DaemonError
+			if errors.As(err, &daemonErr) {
+				log.Fatalf("Job %s rejected: %s", name, daemonErr.Message)
+			}
+			log.Fatalf("Failed to submit job %s: %s", name, err)
 		}
+		log.Printf("Job %s accepted with ID %d", name, accepted.ID)
 	},
 }
 
 var varName string
+var varCpus int
+var varMem string
 
 func init() {
 	RunCmd.Flags().StringVarP(&varName, "name", "n", "", "name of the job")
+	RunCmd.Flags().IntVarP(&varCpus, "cpus", "c", 0, "number of CPU cores requested by the job")
+	RunCmd.Flags().StringVar(&varMem, "mem", "", "memory requested by the job, e.g. 512M or 4G")
 }
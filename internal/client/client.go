@@ -0,0 +1,273 @@
+package client
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/Wesenheit/Skaldenmet/internal/events"
+	"github.com/Wesenheit/Skaldenmet/internal/metrics"
+	"github.com/Wesenheit/Skaldenmet/internal/proces"
+	"github.com/Wesenheit/Skaldenmet/internal/protocol"
+	"github.com/Wesenheit/Skaldenmet/internal/remote"
+	"github.com/Wesenheit/Skaldenmet/internal/sockets"
+)
+
+// dialTimeout bounds connecting to a remote daemon
+const dialTimeout = 5 * time.Second
+
+// DaemonError is returned when the daemon answered a request with an error
+type DaemonError struct {
+	Message string
+}
+
+func (e *DaemonError) Error() string {
+	return "daemon: " + e.Message
+}
+
+// Client talks to the daemon over a single connection, requests are sent
+// one at a time and each response is matched against its request ID.
+type Client struct {
+	conn   net.Conn
+	proto  *protocol.Conn
+	nextID uint64
+}
+
+func Dial(socketPath string) (*Client, error) {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to daemon: %w", err)
+	}
+	return open(conn)
+}
+
+// DialTLS connects to the TLS listener of a remote daemon
+func DialTLS(address string, config *tls.Config) (*Client, error) {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", remote.Address(address), config)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to daemon at %s: %w", address, err)
+	}
+	return open(conn)
+}
+
+// Connect dials the daemon chosen on the command line, a remote one given by
+// --host or the local daemon found in sockets.ClientDir.
+func Connect() (*Client, error) {
+	host := remote.Target()
+	if host == "" {
+		return Dial(sockets.Control(sockets.ClientDir()))
+	}
+	config, err := remote.ClientFiles().ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return DialTLS(host, config)
+}
+
+func open(conn net.Conn) (*Client, error) {
+	c := &Client{conn: conn, proto: protocol.NewConn(conn)}
+	if err := c.handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) handshake() error {
+	if err := c.proto.Send(protocol.Hello{Version: protocol.Version, Client: "met"}); err != nil {
+		return fmt.Errorf("failed to send handshake: %w", err)
+	}
+	var response protocol.Response
+	if err := c.proto.Receive(&response); err != nil {
+		return fmt.Errorf("failed to read handshake: %w", err)
+	}
+	if !response.OK {
+		return &DaemonError{Message: response.Error}
+	}
+	return nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// SetDeadline bounds all following requests, see net.Conn
+func (c *Client) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *Client) send(request protocol.Request) (uint64, error) {
+	c.nextID++
+	request.ID = c.nextID
+	if err := c.proto.Send(request); err != nil {
+		return 0, fmt.Errorf("failed to encode: %w", err)
+	}
+	return request.ID, nil
+}
+
+func (c *Client) receive(id uint64) (protocol.Response, error) {
+	var response protocol.Response
+	if err := c.proto.Receive(&response); err != nil {
+		return response, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if response.ID != id {
+		return response, fmt.Errorf("response to request %d while waiting for %d", response.ID, id)
+	}
+	if !response.OK {
+		return response, &DaemonError{Message: response.Error}
+	}
+	return response, nil
+}
+
+// Do sends a request and decodes the data of the response into data, which
+// may be nil when the response carries none.
+func (c *Client) Do(request protocol.Request, data any) error {
+	id, err := c.send(request)
+	if err != nil {
+		return err
+	}
+	response, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if data == nil || len(response.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(response.Data, data); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+func get[T any](c *Client, request protocol.Request) (T, error) {
+	var data T
+	err := c.Do(request, &data)
+	return data, err
+}
+
+func (c *Client) Jobs() (map[int32]proces.Process, error) {
+	return get[map[int32]proces.Process](c, protocol.Request{Type: protocol.TypeJobs})
+}
+
+// Summaries reads the summaries of a registered kind, every kind is
+// requested by its name
+func (c *Client) Summaries(kind *metrics.Kind) (map[int32]metrics.Summary, error) {
+	raw, err := get[map[int32]json.RawMessage](c, protocol.Request{Type: kind.Name})
+	if err != nil {
+		return nil, err
+	}
+	summaries := make(map[int32]metrics.Summary, len(raw))
+	for job, data := range raw {
+		summary, err := kind.DecodeSummary(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s summary of job %d: %w", kind.Name, job, err)
+		}
+		summaries[job] = summary
+	}
+	return summaries, nil
+}
+
+// SummaryOf reads the summary of the kind named kind of a single job, the
+// zero summary when the job has none
+func SummaryOf[S metrics.Summary](c *Client, kind string, job int32) (S, error) {
+	var summary S
+	registered, ok := metrics.LookupKind(kind)
+	if !ok {
+		return summary, fmt.Errorf("unknown kind %q", kind)
+	}
+	summaries, err := c.Summaries(registered)
+	if err != nil {
+		return summary, err
+	}
+	if found, ok := summaries[job].(S); ok {
+		summary = found
+	}
+	return summary, nil
+}
+
+func (c *Client) Processes(job int32) (map[int32]metrics.ProcessSummary, error) {
+	return get[map[int32]metrics.ProcessSummary](c, protocol.Request{Type: protocol.TypeProcesses, Job: job})
+}
+
+func (c *Client) Series(job int32) ([]events.Sample, error) {
+	return get[[]events.Sample](c, protocol.Request{Type: protocol.TypeSeries, Job: job})
+}
+
+// Node is the data of a single daemon in a cluster answer
+type Node[T any] struct {
+	Name  string
+	Error string
+	Data  T
+}
+
+// Gather sends the request to the cluster of the daemon, the daemon itself
+// included. Nodes that failed keep the zero value of T and their error.
+func Gather[T any](c *Client, request protocol.Request) ([]Node[T], error) {
+	request.Cluster = true
+	var results []protocol.NodeResult
+	if err := c.Do(request, &results); err != nil {
+		return nil, err
+	}
+	nodes := make([]Node[T], 0, len(results))
+	for _, result := range results {
+		node := Node[T]{Name: result.Node, Error: result.Error}
+		if result.Error == "" && len(result.Data) > 0 {
+			if err := json.Unmarshal(result.Data, &node.Data); err != nil {
+				node.Error = fmt.Sprintf("failed to decode response: %v", err)
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// Cancel terminates a running job
+func (c *Client) Cancel(job int32) error {
+	return c.Do(protocol.Request{Type: protocol.TypeCancel, Job: job}, nil)
+}
+
+// Reload makes the daemon apply its config file again, the returned lines
+// describe the changes
+func (c *Client) Reload() ([]string, error) {
+	return get[[]string](c, protocol.Request{Type: protocol.TypeReload})
+}
+
+// ExitDir asks the daemon for the directory where a job started by the
+// client records its exit status, the daemon creates it for the user
+func (c *Client) ExitDir() (string, error) {
+	return get[string](c, protocol.Request{Type: protocol.TypeExitDir})
+}
+
+// Register announces a started job to the daemon and returns the job as
+// accepted by the daemon, with its ID assigned. A rejected job yields a
+// DaemonError carrying the reason.
+func (c *Client) Register(proc proces.Process) (proces.Process, error) {
+	return get[proces.Process](c, protocol.Request{Type: protocol.TypeRegister, Process: &proc})
+}
+
+// Subscribe streams events matching the request to handler until the
+// connection is closed or handler returns an error. The connection cannot be
+// used for other requests afterwards.
+func (c *Client) Subscribe(request protocol.Request, handler func(event json.RawMessage) error) error {
+	request.Type = protocol.TypeSubscribe
+	id, err := c.send(request)
+	if err != nil {
+		return err
+	}
+	for {
+		response, err := c.receive(id)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := handler(response.Data); err != nil {
+			return err
+		}
+	}
+}
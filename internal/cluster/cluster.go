@@ -0,0 +1,146 @@
+package cluster
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Wesenheit/Skaldenmet/internal/client"
+	"github.com/Wesenheit/Skaldenmet/internal/metrics"
+	"github.com/Wesenheit/Skaldenmet/internal/proces"
+	"github.com/Wesenheit/Skaldenmet/internal/protocol"
+	"github.com/Wesenheit/Skaldenmet/internal/remote"
+
+	"github.com/spf13/viper"
+)
+
+// Peer is another daemon queried by this one
+type Peer struct {
+	Name    string `mapstructure:"name"`
+	Address string `mapstructure:"address"`
+}
+
+// Cluster forwards queries to the peers listed in cluster.peers and labels
+// every answer with the name of the node it came from.
+type Cluster struct {
+	node    string
+	peers   []Peer
+	timeout time.Duration
+	tls     *tls.Config
+}
+
+func New(v *viper.Viper) (*Cluster, error) {
+	c := &Cluster{node: v.GetString("cluster.node"), timeout: 5 * time.Second}
+	if c.node == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("cluster.node is not set and the host name is unknown: %w", err)
+		}
+		c.node = hostname
+	}
+	if v.IsSet("cluster.timeout") {
+		c.timeout = v.GetDuration("cluster.timeout")
+		if c.timeout <= 0 {
+			return nil, fmt.Errorf("invalid cluster.timeout %q", v.GetString("cluster.timeout"))
+		}
+	}
+	if err := v.UnmarshalKey("cluster.peers", &c.peers); err != nil {
+		return nil, fmt.Errorf("invalid cluster.peers: %w", err)
+	}
+	if len(c.peers) == 0 {
+		return c, nil
+	}
+
+	names := map[string]bool{c.node: true}
+	for _, peer := range c.peers {
+		if peer.Name == "" || peer.Address == "" {
+			return nil, errors.New("every peer in cluster.peers needs a name and an address")
+		}
+		if names[peer.Name] {
+			return nil, fmt.Errorf("node name %q used twice in the cluster", peer.Name)
+		}
+		names[peer.Name] = true
+	}
+	config, err := remote.DaemonFiles(v).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("cluster peers: %w", err)
+	}
+	c.tls = config
+	return c, nil
+}
+
+// Node is the name of this daemon
+func (c *Cluster) Node() string {
+	return c.node
+}
+
+// Queryable tells whether requests of the type may be sent to the cluster,
+// only summaries keyed by job can be merged.
+func Queryable(kind string) bool {
+	if kind == protocol.TypeJobs {
+		return true
+	}
+	_, ok := metrics.LookupKind(kind)
+	return ok
+}
+
+// Query answers the request on every node, local answers it on this one. When
+// keep is set only jobs it accepts are returned by the peers, they cannot
+// tell which jobs a user of this node may see.
+func (c *Cluster) Query(request protocol.Request, local protocol.Response, keep func(proces.Process) bool) []protocol.NodeResult {
+	results := make([]protocol.NodeResult, len(c.peers)+1)
+	results[0] = protocol.NodeResult{Node: c.node, Error: local.Error, Data: local.Data}
+
+	request.Cluster = false
+	var wg sync.WaitGroup
+	for i, peer := range c.peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := protocol.NodeResult{Node: peer.Name}
+			data, err := c.ask(peer, request, keep)
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Data = data
+			}
+			results[i+1] = result
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (c *Cluster) ask(peer Peer, request protocol.Request, keep func(proces.Process) bool) (json.RawMessage, error) {
+	conn, err := client.DialTLS(peer.Address, c.tls)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout))
+
+	if keep == nil {
+		var data json.RawMessage
+		err := conn.Do(request, &data)
+		return data, err
+	}
+
+	jobs, err := conn.Jobs()
+	if err != nil {
+		return nil, err
+	}
+	var data map[int32]json.RawMessage
+	if err := conn.Do(request, &data); err != nil {
+		return nil, err
+	}
+	for pgid := range data {
+		if job, ok := jobs[pgid]; !ok || !keep(job) {
+			delete(data, pgid)
+		}
+	}
+	return json.Marshal(data)
+}
@@ -1,8 +1,13 @@
 package collectors
 
 import (
+	"bufio"
 	"errors"
+	"fmt"
 	"github.com/Wesenheit/Skaldenmet/internal/metrics"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/shirou/gopsutil/v4/process"
@@ -12,14 +17,39 @@ import (
 type Collector interface {
 	Name() string
 	Collect(storage_chan chan []metrics.Metric, targets map[int32]int32) error
+	// Flush sends samples still buffered, e.g. when the collector stops
+	Flush(storage_chan chan []metrics.Metric)
 	Interval() time.Duration
+	// MaxAge bounds how long samples wait in the buffer when it does not fill
+	MaxAge() time.Duration
 	Finalize() error
 }
 
+// flush sends a copy of buffer and returns it emptied for reuse
+func flush(storage_chan chan []metrics.Metric, buffer []metrics.Metric) []metrics.Metric {
+	if len(buffer) == 0 {
+		return buffer
+	}
+	out := make([]metrics.Metric, len(buffer))
+	copy(out, buffer)
+
+	storage_chan <- out
+	return buffer[:0]
+}
+
+type processInfo struct {
+	parent  int32
+	cmdline string
+	created time.Time
+}
+
 type CpuBaseCollector struct {
 	timout time.Duration
 	buffer []metrics.Metric
 	size   int
+	maxAge time.Duration
+	pss    bool
+	info   map[int32]processInfo
 }
 
 func NewCpuBaseCollector(v *viper.Viper) (*CpuBaseCollector, error) {
@@ -33,15 +63,24 @@ func NewCpuBaseCollector(v *viper.Viper) (*CpuBaseCollector, error) {
 		return nil, errors.New("Wrong size")
 	}
 
+	maxAge := v.GetDuration("cpuCollector.maxAge")
+	if maxAge <= 0 {
+		return nil, errors.New("Wrong max age")
+	}
+
 	return &CpuBaseCollector{
 		timout: duration,
 		buffer: []metrics.Metric{},
 		size:   size,
+		maxAge: maxAge,
+		pss:    v.GetBool("cpuCollector.pss"),
+		info:   make(map[int32]processInfo),
 	}, nil
 
 }
 
 func (c *CpuBaseCollector) Collect(storage_chan chan []metrics.Metric, targets map[int32]int32) error {
+	now := time.Now()
 
 	for pid, ppid := range targets {
 		p, err := process.NewProcess(pid)
@@ -59,28 +98,101 @@ func (c *CpuBaseCollector) Collect(storage_chan chan []metrics.Metric, targets m
 			continue
 		}
 
+		memInfo, err := p.MemoryInfo()
+		if err != nil {
+			continue
+		}
+
 		newMetric := &metrics.CPUMetric{
 			Pid_id: pid,
 			PPID:   ppid,
 			CPU:    cpuPer,
 			Memory: float64(memPer),
-			Time:   time.Now(),
+			RSS:    memInfo.RSS,
+			VMS:    memInfo.VMS,
+			Time:   now,
+		}
+		newMetric.Swap, _ = readSwap(pid)
+		if c.pss {
+			newMetric.PSS, _ = readPSS(pid)
+		}
+		info, first := c.processInfo(p)
+		newMetric.Parent = info.parent
+		newMetric.Created = info.created
+		// the command line does not change, the summary keeps it from the
+		// first sample of the process
+		if first {
+			newMetric.Cmdline = info.cmdline
 		}
 
 		c.buffer = append(c.buffer, newMetric)
 	}
 
-	if len(c.buffer) >= c.size {
-		out := make([]metrics.Metric, len(c.buffer))
-		copy(out, c.buffer)
+	for pid := range c.info {
+		if _, ok := targets[pid]; !ok {
+			delete(c.info, pid)
+		}
+	}
 
-		storage_chan <- out
-		c.buffer = c.buffer[:0]
+	if len(c.buffer) >= c.size {
+		c.Flush(storage_chan)
 	}
 
 	return nil
 }
 
+// processInfo caches static process attributes and reports whether they were
+// read for the first time, the parent may change when a process is reparented
+// so it is refreshed on every call.
+func (c *CpuBaseCollector) processInfo(p *process.Process) (processInfo, bool) {
+	info, ok := c.info[p.Pid]
+	if !ok {
+		info.cmdline, _ = p.Cmdline()
+		if created, err := p.CreateTime(); err == nil {
+			info.created = time.UnixMilli(created)
+		}
+	}
+	if parent, err := p.Ppid(); err == nil {
+		info.parent = parent
+	}
+	c.info[p.Pid] = info
+	return info, !ok
+}
+
+// readPSS returns proportional set size in bytes from smaps_rollup, shared
+// pages are split between processes so summing over a job does not double count.
+func readPSS(pid int32) (uint64, error) {
+	return readProcKB(fmt.Sprintf("/proc/%d/smaps_rollup", pid), "Pss:")
+}
+
+// readSwap returns swapped out memory in bytes from VmSwap of the status file,
+// gopsutil reads only statm which has no swap.
+func readSwap(pid int32) (uint64, error) {
+	return readProcKB(fmt.Sprintf("/proc/%d/status", pid), "VmSwap:")
+}
+
+// readProcKB reads a "Key: value kB" line of a proc file in bytes
+func readProcKB(path, key string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == key {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb * 1024, nil
+		}
+	}
+	return 0, fmt.Errorf("%s not found in %s", strings.TrimSuffix(key, ":"), path)
+}
+
 func (c *CpuBaseCollector) Name() string {
 	return "BaseCPU"
 }
@@ -89,6 +201,14 @@ func (c *CpuBaseCollector) Interval() time.Duration {
 	return c.timout
 }
 
+func (c *CpuBaseCollector) MaxAge() time.Duration {
+	return c.maxAge
+}
+
+func (c *CpuBaseCollector) Flush(storage_chan chan []metrics.Metric) {
+	c.buffer = flush(storage_chan, c.buffer)
+}
+
 func (c *CpuBaseCollector) Finalize() error {
 	return nil
 }
@@ -0,0 +1,322 @@
+package collectors
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/Wesenheit/Skaldenmet/internal/metrics"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	execDefaultInterval = time.Second
+	execDefaultSize     = 10
+	execDefaultMaxAge   = 10 * time.Second
+	// execMaxLine bounds a single answer of a long-lived program
+	execMaxLine = 16 << 20
+)
+
+// ExecSettings configures one entry of execCollectors, zero values are
+// replaced by the defaults of the built-in collectors and the timeout
+// defaults to the interval.
+type ExecSettings struct {
+	Name       string        `mapstructure:"name"`
+	Command    []string      `mapstructure:"command"`
+	Interval   time.Duration `mapstructure:"interval"`
+	Size       int           `mapstructure:"size"`
+	MaxAge     time.Duration `mapstructure:"maxAge"`
+	Timeout    time.Duration `mapstructure:"timeout"`
+	Persistent bool          `mapstructure:"persistent"`
+}
+
+// ExecInput is written to the program on every interval
+type ExecInput struct {
+	Time time.Time `json:"time"`
+	// Targets maps every monitored PID to the PGID of its job
+	Targets map[int32]int32 `json:"targets"`
+}
+
+// ExecRecord is a single value reported by the program. It belongs to the
+// process PID, or to the whole job Job when PID is zero. Aggregation is one of
+// the kinds of metrics.Aggregations and defaults to the mean.
+type ExecRecord struct {
+	PID         int32             `json:"pid,omitempty"`
+	Job         int32             `json:"job,omitempty"`
+	Name        string            `json:"name"`
+	Unit        string            `json:"unit,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Aggregation string            `json:"aggregation,omitempty"`
+	Value       float64           `json:"value"`
+}
+
+// ExecCollectors reads the external collectors of the config
+func ExecCollectors(v *viper.Viper) ([]ExecSettings, error) {
+	var settings []ExecSettings
+	if err := v.UnmarshalKey("execCollectors", &settings); err != nil {
+		return nil, fmt.Errorf("execCollectors: %w", err)
+	}
+	return settings, nil
+}
+
+// execChild is the running program of a persistent collector
+type execChild struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	lines chan []byte
+}
+
+// ExecCollector runs an external program, either once per interval or as a
+// long-lived child answering one line per interval. The program reads an
+// ExecInput as JSON on stdin and answers with a JSON array of ExecRecord.
+type ExecCollector struct {
+	name       string
+	command    []string
+	timeout    time.Duration
+	interval   time.Duration
+	buffer     []metrics.Metric
+	size       int
+	maxAge     time.Duration
+	persistent bool
+	child      *execChild
+}
+
+func NewExecCollector(settings ExecSettings) (*ExecCollector, error) {
+	if settings.Name == "" {
+		return nil, errors.New("Missing name")
+	}
+	if len(settings.Command) == 0 {
+		return nil, errors.New("Missing command")
+	}
+	if _, err := exec.LookPath(settings.Command[0]); err != nil {
+		return nil, err
+	}
+	if settings.Interval < 0 || settings.Size < 0 || settings.MaxAge < 0 || settings.Timeout < 0 {
+		return nil, errors.New("Negative interval, size, max age or timeout")
+	}
+
+	c := &ExecCollector{
+		name:       settings.Name,
+		command:    settings.Command,
+		interval:   orDefault(settings.Interval, execDefaultInterval),
+		size:       orDefault(settings.Size, execDefaultSize),
+		maxAge:     orDefault(settings.MaxAge, execDefaultMaxAge),
+		persistent: settings.Persistent,
+		buffer:     []metrics.Metric{},
+	}
+	c.timeout = orDefault(settings.Timeout, c.interval)
+	return c, nil
+}
+
+// orDefault returns value, or fallback when value is unset
+func orDefault[T int | time.Duration](value, fallback T) T {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
+
+func (c *ExecCollector) Collect(storage_chan chan []metrics.Metric, targets map[int32]int32) error {
+	now := time.Now()
+	input, err := json.Marshal(ExecInput{Time: now, Targets: targets})
+	if err != nil {
+		return err
+	}
+
+	var answer []byte
+	if c.persistent {
+		answer, err = c.ask(input)
+	} else {
+		answer, err = c.run(input)
+	}
+	// a failing program is reported and tried again, it must not stop the
+	// collector for good
+	if err != nil {
+		log.Printf("%s: %v", c.name, err)
+		return nil
+	}
+
+	var records []ExecRecord
+	if err := json.Unmarshal(answer, &records); err != nil {
+		log.Printf("%s: invalid answer: %v", c.name, err)
+		return nil
+	}
+	jobs := make(map[int32]bool)
+	for _, job := range targets {
+		jobs[job] = true
+	}
+	for _, record := range records {
+		metric := &metrics.Sample{
+			Name:        c.name + "/" + record.Name,
+			Unit:        record.Unit,
+			Labels:      record.Labels,
+			Aggregation: metrics.Aggregation(record.Aggregation),
+			Value:       record.Value,
+			Time:        now,
+		}
+		switch job, ok := targets[record.PID]; {
+		case record.Name == "":
+			continue
+		case !metrics.ValidAggregation(metric.Aggregation):
+			log.Printf("%s: unknown aggregation %q of %s", c.name, record.Aggregation, record.Name)
+			continue
+		case record.PID != 0 && ok:
+			metric.Pid_id, metric.PPID = record.PID, job
+		case record.PID == 0 && jobs[record.Job]:
+			metric.Pid_id, metric.PPID = record.Job, record.Job
+		default:
+			continue
+		}
+		c.buffer = append(c.buffer, metric)
+	}
+
+	if len(c.buffer) >= c.size {
+		c.Flush(storage_chan)
+	}
+	return nil
+}
+
+// run starts the program for a single answer
+func (c *ExecCollector) run(input []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.command[0], c.command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// children of the program keeping stdout open must not block us
+	cmd.WaitDelay = time.Second
+	output, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("no answer within %s", c.timeout)
+	}
+	return output, err
+}
+
+// ask sends input to the long-lived program, starting it when needed. A
+// program that exits or does not answer in time is started again on the next
+// interval.
+func (c *ExecCollector) ask(input []byte) ([]byte, error) {
+	if c.child == nil {
+		child, err := startChild(c.command)
+		if err != nil {
+			return nil, err
+		}
+		c.child = child
+	}
+	// answers arriving after their timeout belong to earlier requests
+	for len(c.child.lines) > 0 {
+		<-c.child.lines
+	}
+	if _, err := c.child.stdin.Write(append(input, '\n')); err != nil {
+		c.stopChild()
+		return nil, fmt.Errorf("program exited: %w", err)
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case line, ok := <-c.child.lines:
+		if !ok {
+			c.stopChild()
+			return nil, errors.New("program exited")
+		}
+		return line, nil
+	case <-timer.C:
+		c.child.kill()
+		c.stopChild()
+		return nil, fmt.Errorf("no answer within %s, restarting the program", c.timeout)
+	}
+}
+
+func startChild(command []string) (*execChild, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stderr = os.Stderr
+	// the program and its children are killed together, and children keeping
+	// stdout open must not block Wait
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.WaitDelay = time.Second
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	child := &execChild{cmd: cmd, stdin: stdin, lines: make(chan []byte, 1)}
+	go func() {
+		defer close(child.lines)
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 0, 64*1024), execMaxLine)
+		for scanner.Scan() {
+			child.lines <- bytes.Clone(scanner.Bytes())
+		}
+	}()
+	return child, nil
+}
+
+// kill ends the process group of the program
+func (child *execChild) kill() {
+	syscall.Kill(-child.cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// stopChild closes stdin of the program, which should make it exit, and kills
+// its process group if it is still running after the timeout
+func (c *ExecCollector) stopChild() {
+	if c.child == nil {
+		return
+	}
+	child := c.child
+	c.child = nil
+	child.stdin.Close()
+	kill := time.AfterFunc(c.timeout, child.kill)
+	defer kill.Stop()
+	// drain answers nobody waits for, Wait closes stdout once the program
+	// exited, which ends the reader
+	go func() {
+		for range child.lines {
+		}
+	}()
+	child.cmd.Wait()
+	// children left behind by the program belong to the collector as well
+	child.kill()
+}
+
+func (c *ExecCollector) Name() string {
+	return c.name
+}
+
+func (c *ExecCollector) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *ExecCollector) MaxAge() time.Duration {
+	return c.maxAge
+}
+
+func (c *ExecCollector) Flush(storage_chan chan []metrics.Metric) {
+	c.buffer = flush(storage_chan, c.buffer)
+}
+
+func (c *ExecCollector) Finalize() error {
+	c.stopChild()
+	return nil
+}
@@ -0,0 +1,146 @@
+package collectors
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Wesenheit/Skaldenmet/internal/metrics"
+
+	"github.com/shirou/gopsutil/v4/process"
+	"github.com/spf13/viper"
+)
+
+type ioSample struct {
+	counters *process.IOCountersStat
+	time     time.Time
+	parent   int32
+}
+
+// IOCollector samples /proc/<pid>/io and reports deltas since the previous
+// sample of the same process, the first sample of a process is a baseline.
+// The counters of a process include children it has reaped, so the last
+// counters seen of a process that is gone are taken off the next delta of its
+// parent and only the part that was not sampled is counted again.
+type IOCollector struct {
+	timeout  time.Duration
+	buffer   []metrics.Metric
+	size     int
+	maxAge   time.Duration
+	previous map[int32]ioSample
+}
+
+func NewIOCollector(v *viper.Viper) (*IOCollector, error) {
+	duration := v.GetDuration("ioCollector.interval")
+	if duration <= 0 {
+		return nil, errors.New("Wrong interval in seconds")
+	}
+
+	size := v.GetInt("ioCollector.size")
+	if size <= 0 {
+		return nil, errors.New("Wrong size")
+	}
+
+	maxAge := v.GetDuration("ioCollector.maxAge")
+	if maxAge <= 0 {
+		return nil, errors.New("Wrong max age")
+	}
+
+	return &IOCollector{
+		timeout:  duration,
+		buffer:   []metrics.Metric{},
+		size:     size,
+		maxAge:   maxAge,
+		previous: make(map[int32]ioSample),
+	}, nil
+}
+
+func (c *IOCollector) Collect(storage_chan chan []metrics.Metric, targets map[int32]int32) error {
+	now := time.Now()
+
+	current := make(map[int32]ioSample, len(targets))
+	for pid := range targets {
+		p, err := process.NewProcess(pid)
+		if err != nil {
+			continue
+		}
+		counters, err := p.IOCounters()
+		if err != nil {
+			continue
+		}
+		parent, _ := p.Ppid()
+		current[pid] = ioSample{counters: counters, time: now, parent: parent}
+	}
+
+	// counters of children reaped since the previous sample that were
+	// already reported
+	reaped := make(map[int32]process.IOCountersStat)
+	for pid, prev := range c.previous {
+		if _, alive := current[pid]; alive {
+			continue
+		}
+		if _, tracked := current[prev.parent]; tracked {
+			counted := reaped[prev.parent]
+			counted.DiskReadBytes += prev.counters.DiskReadBytes
+			counted.DiskWriteBytes += prev.counters.DiskWriteBytes
+			counted.ReadCount += prev.counters.ReadCount
+			counted.WriteCount += prev.counters.WriteCount
+			reaped[prev.parent] = counted
+		}
+	}
+
+	for pid, sample := range current {
+		prev, seen := c.previous[pid]
+		if !seen {
+			continue
+		}
+		counted := reaped[pid]
+		newMetric := &metrics.IOMetric{
+			Pid_id:     pid,
+			PPID:       targets[pid],
+			Time:       now,
+			ReadBytes:  delta(sample.counters.DiskReadBytes, prev.counters.DiskReadBytes+counted.DiskReadBytes),
+			WriteBytes: delta(sample.counters.DiskWriteBytes, prev.counters.DiskWriteBytes+counted.DiskWriteBytes),
+			ReadCalls:  delta(sample.counters.ReadCount, prev.counters.ReadCount+counted.ReadCount),
+			WriteCalls: delta(sample.counters.WriteCount, prev.counters.WriteCount+counted.WriteCount),
+		}
+		if elapsed := now.Sub(prev.time).Seconds(); elapsed > 0 {
+			newMetric.ReadRate = float64(newMetric.ReadBytes) / elapsed
+			newMetric.WriteRate = float64(newMetric.WriteBytes) / elapsed
+		}
+		c.buffer = append(c.buffer, newMetric)
+	}
+	c.previous = current
+
+	if len(c.buffer) >= c.size {
+		c.Flush(storage_chan)
+	}
+
+	return nil
+}
+
+func delta(current, previous uint64) uint64 {
+	if current < previous {
+		return 0
+	}
+	return current - previous
+}
+
+func (c *IOCollector) Name() string {
+	return "IO"
+}
+
+func (c *IOCollector) Interval() time.Duration {
+	return c.timeout
+}
+
+func (c *IOCollector) MaxAge() time.Duration {
+	return c.maxAge
+}
+
+func (c *IOCollector) Flush(storage_chan chan []metrics.Metric) {
+	c.buffer = flush(storage_chan, c.buffer)
+}
+
+func (c *IOCollector) Finalize() error {
+	return nil
+}
@@ -0,0 +1,253 @@
+package collectors
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// migSamples keeps two GPM samples per GPU instance, utilisation of a MIG
+// instance is only available as a difference between consecutive samples.
+type migSamples struct {
+	previous nvml.GpmSample
+	current  nvml.GpmSample
+	valid    bool
+}
+
+type MigInstance struct {
+	UUID            string
+	GpuInstance     int
+	ComputeInstance int
+	State           *NVIDIADeviceState
+
+	// Multiprocessors of the instance, the power of the parent is shared
+	// between instances in proportion to them
+	Multiprocessors int
+	// Ready is false until the instance has a utilisation sample
+	Ready bool
+}
+
+func IsMigEnabled(device nvml.Device) bool {
+	mode, _, ret := nvml.DeviceGetMigMode(device)
+	return ret == nvml.SUCCESS && mode == nvml.DEVICE_MIG_ENABLE
+}
+
+// MonitorMigDevice reads per-instance memory and utilisation, temperature and
+// extended fields are shared by all instances and are copied from the parent.
+// GPM samples are per GPU instance, giUtil caches them for compute instances
+// sharing the same GPU instance within a single collection.
+func (c *NVIDIAMonitor) MonitorMigDevice(parent nvml.Device, device_id int, mig nvml.Device, shared *NVIDIADeviceState, giUtil map[int]*float64) (*MigInstance, error) {
+	uuid, ret := nvml.DeviceGetUUID(mig)
+	if ret != nvml.SUCCESS {
+		return nil, errors.New("Failed (mig uuid)")
+	}
+	gi, ret := nvml.DeviceGetGpuInstanceId(mig)
+	if ret != nvml.SUCCESS {
+		return nil, errors.New("Failed (gpu instance)")
+	}
+	ci, ret := nvml.DeviceGetComputeInstanceId(mig)
+	if ret != nvml.SUCCESS {
+		return nil, errors.New("Failed (compute instance)")
+	}
+	memInfo, ret := nvml.DeviceGetMemoryInfo(mig)
+	if ret != nvml.SUCCESS {
+		return nil, errors.New("Failed (mig mem)")
+	}
+
+	var util float64
+	var ready bool
+	if c.gpmSupported(parent, device_id) {
+		cached, ok := giUtil[gi]
+		if !ok {
+			if value, valid := c.migUtilization(parent, device_id, gi); valid {
+				cached = &value
+			}
+			giUtil[gi] = cached
+		}
+		if cached != nil {
+			util, ready = *cached, true
+		}
+	} else {
+		util, ready = c.migProcessUtilization(mig, uuid)
+	}
+
+	state := *shared
+	state.Memory = float64(memInfo.Used) / (1024 * 1024 * 1024)
+	state.MemoryTotal = float64(memInfo.Total) / (1024 * 1024 * 1024)
+	state.Util = util
+	state.PowerW = 0
+
+	instance := &MigInstance{
+		UUID:            uuid,
+		GpuInstance:     gi,
+		ComputeInstance: ci,
+		State:           &state,
+		Ready:           ready,
+	}
+	if attributes, ret := nvml.DeviceGetAttributes(mig); ret == nvml.SUCCESS {
+		instance.Multiprocessors = int(attributes.MultiprocessorCount)
+	}
+	return instance, nil
+}
+
+// migUtilization returns the SM utilisation of a GPU instance between the
+// last two GPM samples, the first sample of an instance has nothing to be
+// compared with and is not valid.
+func (c *NVIDIAMonitor) migUtilization(parent nvml.Device, device_id int, gi int) (float64, bool) {
+	key := [2]int{device_id, gi}
+	samples, ok := c.mig_samples[key]
+	if !ok {
+		previous, ret := nvml.GpmSampleAlloc()
+		if ret != nvml.SUCCESS {
+			return 0, false
+		}
+		current, ret := nvml.GpmSampleAlloc()
+		if ret != nvml.SUCCESS {
+			nvml.GpmSampleFree(previous)
+			return 0, false
+		}
+		samples = &migSamples{previous: previous, current: current}
+		c.mig_samples[key] = samples
+	}
+
+	if nvml.GpmMigSampleGet(parent, gi, samples.current) != nvml.SUCCESS {
+		samples.valid = false
+		return 0, false
+	}
+	var util float64
+	valid := false
+	if samples.valid {
+		query := &nvml.GpmMetricsGetType{
+			NumMetrics: 1,
+			Sample1:    samples.previous,
+			Sample2:    samples.current,
+		}
+		query.Metrics[0].MetricId = uint32(nvml.GPM_METRIC_SM_UTIL)
+		if nvml.GpmMetricsGet(query) == nvml.SUCCESS && query.Metrics[0].NvmlReturn == uint32(nvml.SUCCESS) {
+			util, valid = query.Metrics[0].Value, true
+		}
+	}
+	samples.previous, samples.current = samples.current, samples.previous
+	samples.valid = true
+	return util, valid
+}
+
+// migProcessUtilization is used when GPM is not supported, it sums the mean
+// SM utilisation of every process sampled on the instance since the last call.
+func (c *NVIDIAMonitor) migProcessUtilization(mig nvml.Device, uuid string) (float64, bool) {
+	lastSeen := c.mig_seen[uuid]
+	samples, ret := nvml.DeviceGetProcessUtilization(mig, lastSeen)
+	if ret == nvml.ERROR_NOT_FOUND {
+		// no process ran on the instance since the last call
+		return 0, true
+	}
+	if ret != nvml.SUCCESS {
+		return 0, false
+	}
+
+	total := make(map[uint32]float64)
+	count := make(map[uint32]float64)
+	for _, sample := range samples {
+		total[sample.Pid] += float64(sample.SmUtil)
+		count[sample.Pid]++
+		lastSeen = max(lastSeen, sample.TimeStamp)
+	}
+	c.mig_seen[uuid] = lastSeen
+
+	var util float64
+	for pid := range total {
+		util += total[pid] / count[pid]
+	}
+	return min(util, 100), true
+}
+
+func (c *NVIDIAMonitor) gpmSupported(parent nvml.Device, device_id int) bool {
+	supported, checked := c.gpm_support[device_id]
+	if checked {
+		return supported
+	}
+	support, ret := nvml.GpmQueryDeviceSupport(parent)
+	supported = ret == nvml.SUCCESS && support.IsSupportedDevice != 0
+	if !supported {
+		log.Printf("NVIDIA: GPM not supported on GPU %d, MIG utilisation falls back to process sampling", device_id)
+	}
+	c.gpm_support[device_id] = supported
+	return supported
+}
+
+// CollectMig attributes processes on a MIG enabled GPU to the instance they
+// run on, using GPU and compute instance IDs reported by the parent device.
+// All instances of a sweep share its time, so device-level fields are counted
+// once, and the power of the parent is split by multiprocessors. Instances
+// without a utilisation sample yet are skipped.
+func (c *NVIDIAMonitor) CollectMig(parent nvml.Device, device_id int, targets map[int32]int32) {
+	computeProcs, ret := nvml.DeviceGetComputeRunningProcesses(parent)
+	if ret != nvml.SUCCESS {
+		return
+	}
+
+	count, ret := nvml.DeviceGetMaxMigDeviceCount(parent)
+	if ret != nvml.SUCCESS {
+		return
+	}
+
+	shared := &NVIDIADeviceState{Time: time.Now()}
+	if temp, ret := nvml.DeviceGetTemperature(parent, nvml.TEMPERATURE_GPU); ret == nvml.SUCCESS {
+		shared.Temperature = float64(temp)
+	}
+	if power, ret := nvml.DeviceGetPowerUsage(parent); ret == nvml.SUCCESS {
+		shared.PowerW = float64(power)
+	}
+	c.MonitorExtended(parent, shared)
+
+	instances := []*MigInstance{}
+	multiprocessors := 0
+	giUtil := make(map[int]*float64)
+	for index := 0; index < count; index++ {
+		mig, ret := nvml.DeviceGetMigDeviceHandleByIndex(parent, index)
+		if ret != nvml.SUCCESS {
+			continue
+		}
+
+		instance, err := c.MonitorMigDevice(parent, device_id, mig, shared, giUtil)
+		if err != nil {
+			continue
+		}
+		instances = append(instances, instance)
+		multiprocessors += instance.Multiprocessors
+	}
+
+	for _, instance := range instances {
+		if !instance.Ready {
+			continue
+		}
+		// without multiprocessor counts power is left unattributed
+		if multiprocessors > 0 {
+			instance.State.PowerW = shared.PowerW * float64(instance.Multiprocessors) / float64(multiprocessors)
+		}
+
+		for _, proc := range computeProcs {
+			pid := int32(proc.Pid)
+			if int(proc.GpuInstanceId) != instance.GpuInstance || int(proc.ComputeInstanceId) != instance.ComputeInstance {
+				continue
+			}
+			if ppid, isTarget := targets[pid]; isTarget {
+				metric := DeviceStateToMetric(instance.State, pid, ppid, device_id)
+				metric.MigUUID = instance.UUID
+				metric.GpuInstance = instance.GpuInstance
+				metric.ComputeInstance = instance.ComputeInstance
+				c.buffer = append(c.buffer, metric)
+			}
+		}
+	}
+}
+
+func (c *NVIDIAMonitor) freeMigSamples() {
+	for key, samples := range c.mig_samples {
+		nvml.GpmSampleFree(samples.previous)
+		nvml.GpmSampleFree(samples.current)
+		delete(c.mig_samples, key)
+	}
+}
@@ -0,0 +1,111 @@
+package collectors
+
+import (
+	"encoding/binary"
+	"errors"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	inetDiagInfo   = 2
+	inetDiagReqLen = 56
+	inetDiagMsgLen = 72
+)
+
+type tcpCounters struct {
+	sent uint64
+	recv uint64
+}
+
+// dumpTCPCounters queries sock_diag for all TCP sockets and returns byte
+// counters from tcp_info keyed by socket inode.
+func dumpTCPCounters() (map[uint64]tcpCounters, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_INET_DIAG)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	counters := make(map[uint64]tcpCounters)
+	for _, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		if err := dumpFamily(fd, family, counters); err != nil {
+			return nil, err
+		}
+	}
+	return counters, nil
+}
+
+func dumpFamily(fd int, family uint8, counters map[uint64]tcpCounters) error {
+	request := make([]byte, unix.NLMSG_HDRLEN+inetDiagReqLen)
+	binary.NativeEndian.PutUint32(request[0:4], uint32(len(request)))
+	binary.NativeEndian.PutUint16(request[4:6], unix.SOCK_DIAG_BY_FAMILY)
+	binary.NativeEndian.PutUint16(request[6:8], unix.NLM_F_REQUEST|unix.NLM_F_DUMP)
+	body := request[unix.NLMSG_HDRLEN:]
+	body[0] = family
+	body[1] = unix.IPPROTO_TCP
+	body[2] = 1 << (inetDiagInfo - 1)
+	binary.NativeEndian.PutUint32(body[4:8], 0xffffffff) // all states
+
+	if err := unix.Sendto(fd, request, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return err
+		}
+		messages, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, msg := range messages {
+			switch msg.Header.Type {
+			case unix.NLMSG_DONE:
+				return nil
+			case unix.NLMSG_ERROR:
+				return errors.New("sock_diag request failed")
+			}
+			parseDiagMessage(msg.Data, counters)
+		}
+	}
+}
+
+func parseDiagMessage(data []byte, counters map[uint64]tcpCounters) {
+	if len(data) < inetDiagMsgLen {
+		return
+	}
+	inode := uint64(binary.NativeEndian.Uint32(data[68:72]))
+	if inode == 0 {
+		return
+	}
+
+	attrs := data[inetDiagMsgLen:]
+	for len(attrs) >= unix.SizeofRtAttr {
+		attrLen := int(binary.NativeEndian.Uint16(attrs[0:2]))
+		attrType := binary.NativeEndian.Uint16(attrs[2:4])
+		if attrLen < unix.SizeofRtAttr || attrLen > len(attrs) {
+			return
+		}
+		if attrType == inetDiagInfo {
+			var info unix.TCPInfo
+			raw := (*[unsafe.Sizeof(info)]byte)(unsafe.Pointer(&info))
+			copy(raw[:], attrs[unix.SizeofRtAttr:attrLen])
+			counters[inode] = tcpCounters{sent: info.Bytes_acked, recv: info.Bytes_received}
+			return
+		}
+		aligned := (attrLen + unix.RTA_ALIGNTO - 1) &^ (unix.RTA_ALIGNTO - 1)
+		if aligned > len(attrs) {
+			return
+		}
+		attrs = attrs[aligned:]
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package collectors
+
+import "errors"
+
+type tcpCounters struct {
+	sent uint64
+	recv uint64
+}
+
+func dumpTCPCounters() (map[uint64]tcpCounters, error) {
+	return nil, errors.New("sock_diag is only available on Linux")
+}
@@ -0,0 +1,247 @@
+package collectors
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Wesenheit/Skaldenmet/internal/metrics"
+
+	"github.com/spf13/viper"
+)
+
+type netCounters struct {
+	sent uint64
+	recv uint64
+}
+
+// NetworkCollector attributes traffic to jobs. Processes living in their own
+// network namespace are accounted by the interface counters of the namespace,
+// processes in the host namespace by TCP counters of the sockets they own.
+// The first reading of a namespace or a socket is a baseline, its counters
+// cover traffic from before it was seen. A namespace is accounted to the
+// lowest PID of the job living in it; a namespace shared by several jobs is
+// not accounted and traffic of processes outside any job in a namespace of a
+// job is counted for the job.
+type NetworkCollector struct {
+	timeout   time.Duration
+	buffer    []metrics.Metric
+	size      int
+	maxAge    time.Duration
+	hostNetNS string
+	previous  map[string]netCounters
+	lastTime  time.Time
+	// shared holds namespaces of several jobs, logged once when they appear
+	shared map[string]struct{}
+}
+
+func NewNetworkCollector(v *viper.Viper) (*NetworkCollector, error) {
+	duration := v.GetDuration("networkCollector.interval")
+	if duration <= 0 {
+		return nil, errors.New("Wrong interval in seconds")
+	}
+
+	size := v.GetInt("networkCollector.size")
+	if size <= 0 {
+		return nil, errors.New("Wrong size")
+	}
+
+	maxAge := v.GetDuration("networkCollector.maxAge")
+	if maxAge <= 0 {
+		return nil, errors.New("Wrong max age")
+	}
+
+	hostNetNS, err := os.Readlink("/proc/self/ns/net")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read network namespace: %w", err)
+	}
+
+	return &NetworkCollector{
+		timeout:   duration,
+		buffer:    []metrics.Metric{},
+		size:      size,
+		maxAge:    maxAge,
+		hostNetNS: hostNetNS,
+		previous:  make(map[string]netCounters),
+		shared:    make(map[string]struct{}),
+	}, nil
+}
+
+func (c *NetworkCollector) Collect(storage_chan chan []metrics.Metric, targets map[int32]int32) error {
+	now := time.Now()
+	current := make(map[string]netCounters)
+	perPid := make(map[int32]netCounters)
+
+	namespaces := make(map[string]int32)
+	shared := make(map[string]struct{})
+	socketOwners := make(map[uint64]int32)
+	for pid, job := range targets {
+		netns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/net", pid))
+		if err != nil {
+			continue
+		}
+		if netns != c.hostNetNS {
+			owner, seen := namespaces[netns]
+			switch {
+			case !seen || job == targets[owner] && pid < owner:
+				namespaces[netns] = pid
+			case job != targets[owner]:
+				shared[netns] = struct{}{}
+			}
+			continue
+		}
+		for _, inode := range socketInodes(pid) {
+			if _, seen := socketOwners[inode]; !seen {
+				socketOwners[inode] = pid
+			}
+		}
+	}
+
+	for netns := range shared {
+		delete(namespaces, netns)
+		if _, logged := c.shared[netns]; !logged {
+			log.Printf("Network namespace %s is shared by several jobs, its traffic is not accounted", netns)
+		}
+	}
+	c.shared = shared
+
+	for netns, pid := range namespaces {
+		counters, err := readNetDev(pid)
+		if err != nil {
+			continue
+		}
+		key := "ns:" + netns
+		current[key] = counters
+		c.accumulate(perPid, pid, key, counters)
+	}
+
+	if len(socketOwners) > 0 {
+		sockets, err := dumpTCPCounters()
+		if err == nil {
+			for inode, pid := range socketOwners {
+				tcp, ok := sockets[inode]
+				if !ok {
+					continue
+				}
+				key := "sock:" + strconv.FormatUint(inode, 10)
+				counters := netCounters{sent: tcp.sent, recv: tcp.recv}
+				current[key] = counters
+				c.accumulate(perPid, pid, key, counters)
+			}
+		}
+	}
+
+	elapsed := now.Sub(c.lastTime).Seconds()
+	for pid, counters := range perPid {
+		newMetric := &metrics.NetMetric{
+			Pid_id:    pid,
+			PPID:      targets[pid],
+			BytesSent: counters.sent,
+			BytesRecv: counters.recv,
+			Time:      now,
+		}
+		if !c.lastTime.IsZero() && elapsed > 0 {
+			newMetric.SendRate = float64(counters.sent) / elapsed
+			newMetric.RecvRate = float64(counters.recv) / elapsed
+		}
+		c.buffer = append(c.buffer, newMetric)
+	}
+
+	c.previous = current
+	c.lastTime = now
+
+	if len(c.buffer) >= c.size {
+		c.Flush(storage_chan)
+	}
+
+	return nil
+}
+
+func (c *NetworkCollector) accumulate(perPid map[int32]netCounters, pid int32, key string, counters netCounters) {
+	prev, ok := c.previous[key]
+	if !ok {
+		return
+	}
+	total := perPid[pid]
+	total.sent += delta(counters.sent, prev.sent)
+	total.recv += delta(counters.recv, prev.recv)
+	perPid[pid] = total
+}
+
+func socketInodes(pid int32) []uint64 {
+	fdDir := fmt.Sprintf("/proc/%d/fd", pid)
+	entries, err := os.ReadDir(fdDir)
+	if err != nil {
+		return nil
+	}
+	inodes := []uint64{}
+	for _, entry := range entries {
+		link, err := os.Readlink(fdDir + "/" + entry.Name())
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"), 10, 64)
+		if err == nil {
+			inodes = append(inodes, inode)
+		}
+	}
+	return inodes
+}
+
+// readNetDev sums traffic of all non-loopback interfaces visible to pid
+func readNetDev(pid int32) (netCounters, error) {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/net/dev", pid))
+	if err != nil {
+		return netCounters{}, err
+	}
+	defer file.Close()
+
+	var counters netCounters
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		iface, stats, found := strings.Cut(scanner.Text(), ":")
+		if !found || strings.TrimSpace(iface) == "lo" {
+			continue
+		}
+		fields := strings.Fields(stats)
+		if len(fields) < 9 {
+			continue
+		}
+		recv, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		sent, err := strconv.ParseUint(fields[8], 10, 64)
+		if err != nil {
+			continue
+		}
+		counters.recv += recv
+		counters.sent += sent
+	}
+	return counters, scanner.Err()
+}
+
+func (c *NetworkCollector) Name() string {
+	return "Network"
+}
+
+func (c *NetworkCollector) Interval() time.Duration {
+	return c.timeout
+}
+
+func (c *NetworkCollector) MaxAge() time.Duration {
+	return c.maxAge
+}
+
+func (c *NetworkCollector) Flush(storage_chan chan []metrics.Metric) {
+	c.buffer = flush(storage_chan, c.buffer)
+}
+
+func (c *NetworkCollector) Finalize() error {
+	return nil
+}
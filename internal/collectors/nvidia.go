@@ -2,7 +2,9 @@ package collectors
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"slices"
 	"time"
 
 	"github.com/Wesenheit/Skaldenmet/internal/metrics"
@@ -15,9 +17,16 @@ type NVIDIAMonitor struct {
 	timeout      time.Duration
 	buffer       []metrics.Metric
 	max_size     int
+	maxAge       time.Duration
 	device_count int16
+	extended     map[string]bool
+	mig_samples  map[[2]int]*migSamples
+	mig_seen     map[string]uint64
+	gpm_support  map[int]bool
 }
 
+var NVIDIAExtendedFields = []string{"clocks", "throttle", "membw", "pcie", "ecc", "fan"}
+
 func (c *NVIDIAMonitor) Name() string {
 	return "NVIDIA Monitor"
 }
@@ -29,9 +38,20 @@ func (c *NVIDIAMonitor) Interval() time.Duration {
 type NVIDIADeviceState struct {
 	Util        float64
 	Memory      float64
+	MemoryTotal float64
 	PowerW      float64
 	Temperature float64
 	Time        time.Time
+
+	SMClock         float64
+	MemClock        float64
+	ThrottleReasons uint64
+	MemBandwidth    float64
+	PcieTx          float64
+	PcieRx          float64
+	EccCorrected    uint64
+	EccUncorrected  uint64
+	FanSpeed        float64
 }
 
 func DeviceStateToMetric(device_state *NVIDIADeviceState, pid int32, ppid int32, device_id int) *metrics.GPUMetric {
@@ -40,10 +60,21 @@ func DeviceStateToMetric(device_state *NVIDIADeviceState, pid int32, ppid int32,
 		PPid_id:     ppid,
 		Util:        device_state.Util,
 		Memory:      device_state.Memory,
+		MemoryTotal: device_state.MemoryTotal,
 		Device:      device_id,
 		PowerW:      device_state.PowerW,
 		Temperature: device_state.Temperature,
 		Time:        device_state.Time,
+
+		SMClock:         device_state.SMClock,
+		MemClock:        device_state.MemClock,
+		ThrottleReasons: device_state.ThrottleReasons,
+		MemBandwidth:    device_state.MemBandwidth,
+		PcieTx:          device_state.PcieTx,
+		PcieRx:          device_state.PcieRx,
+		EccCorrected:    device_state.EccCorrected,
+		EccUncorrected:  device_state.EccUncorrected,
+		FanSpeed:        device_state.FanSpeed,
 	}
 }
 
@@ -73,15 +104,63 @@ func (c *NVIDIAMonitor) MonitorDevice(device nvml.Device) (*NVIDIADeviceState, e
 	}
 	metric := &NVIDIADeviceState{
 		Memory:      float64(memInfo.Used) / (1024 * 1024 * 1024),
+		MemoryTotal: float64(memInfo.Total) / (1024 * 1024 * 1024),
 		Util:        float64(utilization.Gpu),
 		Temperature: float64(temp),
 		PowerW:      float64(power),
 		Time:        time.Now(),
 	}
+	if c.extended["membw"] {
+		metric.MemBandwidth = float64(utilization.Memory)
+	}
+	c.MonitorExtended(device, metric)
 
 	return metric, nil
 }
 
+// MonitorExtended reads optional fields; unsupported queries are skipped
+// so a single missing counter does not drop the whole sample.
+func (c *NVIDIAMonitor) MonitorExtended(device nvml.Device, metric *NVIDIADeviceState) {
+	if c.extended["clocks"] {
+		if sm, ret := nvml.DeviceGetClockInfo(device, nvml.CLOCK_SM); ret == nvml.SUCCESS {
+			metric.SMClock = float64(sm)
+		}
+		if mem, ret := nvml.DeviceGetClockInfo(device, nvml.CLOCK_MEM); ret == nvml.SUCCESS {
+			metric.MemClock = float64(mem)
+		}
+	}
+
+	if c.extended["throttle"] {
+		if reasons, ret := nvml.DeviceGetCurrentClocksThrottleReasons(device); ret == nvml.SUCCESS {
+			metric.ThrottleReasons = reasons
+		}
+	}
+
+	if c.extended["pcie"] {
+		if tx, ret := nvml.DeviceGetPcieThroughput(device, nvml.PCIE_UTIL_TX_BYTES); ret == nvml.SUCCESS {
+			metric.PcieTx = float64(tx) / 1024 // KB/s to MB/s
+		}
+		if rx, ret := nvml.DeviceGetPcieThroughput(device, nvml.PCIE_UTIL_RX_BYTES); ret == nvml.SUCCESS {
+			metric.PcieRx = float64(rx) / 1024
+		}
+	}
+
+	if c.extended["ecc"] {
+		if corrected, ret := nvml.DeviceGetTotalEccErrors(device, nvml.MEMORY_ERROR_TYPE_CORRECTED, nvml.VOLATILE_ECC); ret == nvml.SUCCESS {
+			metric.EccCorrected = corrected
+		}
+		if uncorrected, ret := nvml.DeviceGetTotalEccErrors(device, nvml.MEMORY_ERROR_TYPE_UNCORRECTED, nvml.VOLATILE_ECC); ret == nvml.SUCCESS {
+			metric.EccUncorrected = uncorrected
+		}
+	}
+
+	if c.extended["fan"] {
+		if fan, ret := nvml.DeviceGetFanSpeed(device); ret == nvml.SUCCESS {
+			metric.FanSpeed = float64(fan)
+		}
+	}
+}
+
 func (c *NVIDIAMonitor) Collect(storage_chan chan []metrics.Metric, targets map[int32]int32) error {
 	for device_id := 0; device_id < int(c.device_count); device_id++ {
 		device, ret := nvml.DeviceGetHandleByIndex(device_id)
@@ -89,6 +168,11 @@ func (c *NVIDIAMonitor) Collect(storage_chan chan []metrics.Metric, targets map[
 			continue
 		}
 
+		if IsMigEnabled(device) {
+			c.CollectMig(device, device_id, targets)
+			continue
+		}
+
 		computeProcs, ret := device.GetComputeRunningProcesses()
 		if ret != nvml.SUCCESS {
 			continue
@@ -110,17 +194,22 @@ func (c *NVIDIAMonitor) Collect(storage_chan chan []metrics.Metric, targets map[
 	}
 
 	if len(c.buffer) >= c.max_size {
-		out := make([]metrics.Metric, len(c.buffer))
-		copy(out, c.buffer)
-
-		storage_chan <- out
-		c.buffer = c.buffer[:0] // Reuse memory
+		c.Flush(storage_chan)
 	}
 
 	return nil
 }
 
+func (c *NVIDIAMonitor) MaxAge() time.Duration {
+	return c.maxAge
+}
+
+func (c *NVIDIAMonitor) Flush(storage_chan chan []metrics.Metric) {
+	c.buffer = flush(storage_chan, c.buffer)
+}
+
 func (c *NVIDIAMonitor) Finalize() error {
+	c.freeMigSamples()
 	if nvml.Shutdown() == nvml.SUCCESS {
 		return nil
 	} else {
@@ -144,14 +233,46 @@ func NewNVIDIAMonitor(v *viper.Viper) (*NVIDIAMonitor, error) {
 		return nil, errors.New("Wrong size")
 	}
 
+	maxAge := v.GetDuration("nvidiaCollector.maxAge")
+	if maxAge <= 0 {
+		return nil, errors.New("Wrong max age")
+	}
+
 	duration := v.GetDuration("nvidiaCollector.interval")
 	if duration <= 0 {
 		return nil, errors.New("Wrong interval in seconds")
 	}
+
+	extended, err := parseExtended(v.GetStringSlice("nvidiaCollector.extended"))
+	if err != nil {
+		return nil, err
+	}
 	return &NVIDIAMonitor{
 		timeout:      duration,
 		device_count: int16(deviceCount),
 		max_size:     max_size,
+		maxAge:       maxAge,
 		buffer:       []metrics.Metric{},
+		extended:     extended,
+		mig_samples:  make(map[[2]int]*migSamples),
+		mig_seen:     make(map[string]uint64),
+		gpm_support:  make(map[int]bool),
 	}, nil
 }
+
+func parseExtended(fields []string) (map[string]bool, error) {
+	extended := make(map[string]bool)
+	for _, field := range fields {
+		if field == "all" {
+			for _, name := range NVIDIAExtendedFields {
+				extended[name] = true
+			}
+			continue
+		}
+		if !slices.Contains(NVIDIAExtendedFields, field) {
+			return nil, fmt.Errorf("Unknown extended field %q", field)
+		}
+		extended[field] = true
+	}
+	return extended, nil
+}
@@ -0,0 +1,259 @@
+package collectors
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Wesenheit/Skaldenmet/internal/metrics"
+
+	"github.com/shirou/gopsutil/v4/cpu"
+	"github.com/shirou/gopsutil/v4/process"
+	"github.com/spf13/viper"
+)
+
+type raplZone struct {
+	path     string
+	dram     bool
+	maxRange uint64
+	last     uint64
+}
+
+// RAPLCollector reads package and DRAM energy counters of the powercap
+// interface and splits them between jobs by their share of busy CPU time.
+type RAPLCollector struct {
+	timeout  time.Duration
+	buffer   []metrics.Metric
+	size     int
+	maxAge   time.Duration
+	zones    []*raplZone
+	lastBusy float64
+	lastCPU  map[int32]float64
+	lastTime time.Time
+}
+
+func NewRAPLCollector(v *viper.Viper) (*RAPLCollector, error) {
+	duration := v.GetDuration("raplCollector.interval")
+	if duration <= 0 {
+		return nil, errors.New("Wrong interval in seconds")
+	}
+
+	size := v.GetInt("raplCollector.size")
+	if size <= 0 {
+		return nil, errors.New("Wrong size")
+	}
+
+	maxAge := v.GetDuration("raplCollector.maxAge")
+	if maxAge <= 0 {
+		return nil, errors.New("Wrong max age")
+	}
+
+	root := v.GetString("raplCollector.path")
+	if root == "" {
+		root = "/sys/class/powercap"
+	}
+	zones, err := findRAPLZones(root)
+	if err != nil {
+		return nil, err
+	}
+
+	busy, err := busyCPUTime()
+	if err != nil {
+		return nil, err
+	}
+
+	return &RAPLCollector{
+		timeout:  duration,
+		buffer:   []metrics.Metric{},
+		size:     size,
+		maxAge:   maxAge,
+		zones:    zones,
+		lastBusy: busy,
+		lastCPU:  make(map[int32]float64),
+		lastTime: time.Now(),
+	}, nil
+}
+
+// findRAPLZones returns package and DRAM zones of the MSR interface. The
+// intel-rapl-mmio zones mirror the package domain on recent CPUs and would be
+// counted twice, every zone name is only taken once per package for the same
+// reason.
+func findRAPLZones(root string) ([]*raplZone, error) {
+	paths, err := filepath.Glob(filepath.Join(root, "intel-rapl:*"))
+	if err != nil {
+		return nil, err
+	}
+
+	zones := []*raplZone{}
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		name, err := readSysString(filepath.Join(path, "name"))
+		if err != nil {
+			continue
+		}
+		if !strings.HasPrefix(name, "package") && name != "dram" {
+			continue
+		}
+		// intel-rapl:<package>[:<subzone>]
+		key := strings.Split(filepath.Base(path), ":")[1] + "/" + name
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		maxRange, err := readSysUint(filepath.Join(path, "max_energy_range_uj"))
+		if err != nil {
+			continue
+		}
+		energy, err := readSysUint(filepath.Join(path, "energy_uj"))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s (root access is usually required): %w", path, err)
+		}
+		zones = append(zones, &raplZone{
+			path:     filepath.Join(path, "energy_uj"),
+			dram:     name == "dram",
+			maxRange: maxRange,
+			last:     energy,
+		})
+	}
+	if len(zones) == 0 {
+		return nil, errors.New("No RAPL package or DRAM domains found")
+	}
+	return zones, nil
+}
+
+func readSysString(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+func readSysUint(path string) (uint64, error) {
+	content, err := readSysString(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(content, 10, 64)
+}
+
+func busyCPUTime() (float64, error) {
+	times, err := cpu.Times(false)
+	if err != nil {
+		return 0, err
+	}
+	if len(times) == 0 {
+		return 0, errors.New("No CPU times")
+	}
+	t := times[0]
+	return t.Total() - t.Idle - t.Iowait, nil
+}
+
+// readEnergy returns joules consumed since the previous call, counters wrap
+// around at max_energy_range_uj.
+func (c *RAPLCollector) readEnergy() (float64, float64) {
+	var packageJ, dramJ float64
+	for _, zone := range c.zones {
+		energy, err := readSysUint(zone.path)
+		if err != nil {
+			continue
+		}
+		var consumed uint64
+		if energy >= zone.last {
+			consumed = energy - zone.last
+		} else {
+			consumed = zone.maxRange - zone.last + energy
+		}
+		zone.last = energy
+
+		if zone.dram {
+			dramJ += float64(consumed) / 1e6
+		} else {
+			packageJ += float64(consumed) / 1e6
+		}
+	}
+	return packageJ, dramJ
+}
+
+func (c *RAPLCollector) Collect(storage_chan chan []metrics.Metric, targets map[int32]int32) error {
+	now := time.Now()
+	packageJ, dramJ := c.readEnergy()
+
+	busy, err := busyCPUTime()
+	if err != nil {
+		return nil
+	}
+	busyDelta := busy - c.lastBusy
+	c.lastBusy = busy
+
+	current := make(map[int32]float64)
+	for pid, ppid := range targets {
+		p, err := process.NewProcess(pid)
+		if err != nil {
+			continue
+		}
+		times, err := p.Times()
+		if err != nil {
+			continue
+		}
+		cpuTime := times.User + times.System
+		current[pid] = cpuTime
+
+		// Processes spawned since the previous sample used all of their
+		// CPU time within this interval.
+		last, seen := c.lastCPU[pid]
+		if !seen {
+			created, err := p.CreateTime()
+			if err != nil || time.UnixMilli(created).Before(c.lastTime) {
+				continue
+			}
+		}
+		if busyDelta <= 0 {
+			continue
+		}
+		share := min((cpuTime-last)/busyDelta, 1)
+		if share <= 0 {
+			continue
+		}
+
+		c.buffer = append(c.buffer, &metrics.EnergyMetric{
+			Pid_id:   pid,
+			PPID:     ppid,
+			PackageJ: packageJ * share,
+			DramJ:    dramJ * share,
+			Time:     now,
+		})
+	}
+	c.lastCPU = current
+	c.lastTime = now
+
+	if len(c.buffer) >= c.size {
+		c.Flush(storage_chan)
+	}
+
+	return nil
+}
+
+func (c *RAPLCollector) Name() string {
+	return "RAPL"
+}
+
+func (c *RAPLCollector) Interval() time.Duration {
+	return c.timeout
+}
+
+func (c *RAPLCollector) MaxAge() time.Duration {
+	return c.maxAge
+}
+
+func (c *RAPLCollector) Flush(storage_chan chan []metrics.Metric) {
+	c.buffer = flush(storage_chan, c.buffer)
+}
+
+func (c *RAPLCollector) Finalize() error {
+	return nil
+}
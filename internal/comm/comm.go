@@ -1,13 +1,43 @@
 package comm
 
 import (
+	"context"
+
+	"github.com/Wesenheit/Skaldenmet/internal/auth"
+	"github.com/Wesenheit/Skaldenmet/internal/cluster"
+	"github.com/Wesenheit/Skaldenmet/internal/events"
 	"github.com/Wesenheit/Skaldenmet/internal/proces"
 	"github.com/Wesenheit/Skaldenmet/internal/storage"
 )
 
 type CommManager interface {
-	Notify(info proces.Process) error
+	Notify(info proces.Process) (proces.Process, error)
 	Finalize() error
-	StartListening(processChan chan<- proces.Process) error
-	ServeQueries(storage.Storage)
+	Serve(ctx context.Context, backend Backend) error
+}
+
+// Backend is what the daemon exposes to clients of the control socket
+type Backend struct {
+	Storage    storage.Storage
+	Broker     *events.Broker
+	Controller Controller
+	// Admit validates a registered job and fills in the fields assigned by
+	// the daemon, admitted jobs are sent to Processes
+	Admit func(*proces.Process) error
+	// Release gives up an admitted job that could not be sent to the client
+	Release   func(pgid int32)
+	Processes chan<- proces.Process
+	// Cluster answers requests sent to all nodes
+	Cluster *cluster.Cluster
+}
+
+// Controller acts on jobs on behalf of clients
+type Controller interface {
+	Submit(spec proces.Spec, owner auth.Peer) (proces.Process, error)
+	Cancel(pgid int32) error
+	// Reload applies the config file again and describes what changed
+	Reload() ([]string, error)
+	// ExitDir prepares the directory where jobs the owner starts itself
+	// record their exit status
+	ExitDir(owner auth.Peer) (string, error)
 }
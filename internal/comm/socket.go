@@ -1,48 +1,93 @@
 package comm
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
 	"log"
 	"net"
 	"os"
+	"sync"
+	"time"
+
+	"github.com/Wesenheit/Skaldenmet/internal/auth"
+	"github.com/Wesenheit/Skaldenmet/internal/client"
+	"github.com/Wesenheit/Skaldenmet/internal/cluster"
+	"github.com/Wesenheit/Skaldenmet/internal/events"
 	"github.com/Wesenheit/Skaldenmet/internal/proces"
+	"github.com/Wesenheit/Skaldenmet/internal/protocol"
 	"github.com/Wesenheit/Skaldenmet/internal/storage"
 )
 
+const (
+	// handshakeTimeout bounds the time a client has to introduce itself
+	handshakeTimeout = 5 * time.Second
+	// idleTimeout closes connections without requests
+	idleTimeout = time.Minute
+	// writeTimeout bounds a single response, a client that stops reading
+	// is disconnected instead of holding its goroutine forever
+	writeTimeout = 10 * time.Second
+	// acceptBackoff delays accepting after a failed Accept, e.g. when the
+	// daemon ran out of file descriptors
+	acceptBackoff = 100 * time.Millisecond
+)
+
+// UnixSocketMonitor serves the control socket, every connection is handled
+// concurrently and may carry any request type. Remote clients are served the
+// same protocol over an optional TLS listener.
 type UnixSocketMonitor struct {
 	SocketPath string
 	listner    net.Listener
+	remote     net.Listener
+	policy     *auth.Policy
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
 }
 
-func (u *UnixSocketMonitor) Notify(info proces.Process) error {
-	conn, err := net.Dial("unix", u.SocketPath)
+// Notify registers a job and returns it as accepted by the daemon
+func (u *UnixSocketMonitor) Notify(info proces.Process) (proces.Process, error) {
+	c, err := client.Dial(u.SocketPath)
 	if err != nil {
-		return err
+		return info, err
 	}
-	defer conn.Close()
+	defer c.Close()
 
-	return json.NewEncoder(conn).Encode(info)
+	return c.Register(info)
 }
+
 func (u *UnixSocketMonitor) Finalize() error {
-	return u.listner.Close()
+	err := u.listner.Close()
+	if u.remote != nil {
+		err = errors.Join(err, u.remote.Close())
+	}
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
 }
 
-func (u *UnixSocketMonitor) StartListening(processChan chan<- proces.Process) error {
-	for {
-		conn, err := u.listner.Accept()
-		if err != nil {
-			continue
-		}
-		var info proces.Process
-		if err := json.NewDecoder(conn).Decode(&info); err != nil {
-			log.Printf("Error during decoding %s", err)
-		}
-		processChan <- info
-		conn.Close()
+// ListenTLS accepts remote clients on a TCP address, only clients with a
+// certificate trusted by config are let in.
+func (u *UnixSocketMonitor) ListenTLS(address string, config *tls.Config) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
 	}
+	u.AcceptTLS(listener, config)
+	return nil
+}
+
+// AcceptTLS serves remote clients on an open TCP listener, e.g. one passed by
+// systemd socket activation
+func (u *UnixSocketMonitor) AcceptTLS(listener net.Listener, config *tls.Config) {
+	u.remote = tls.NewListener(listener, config)
+	log.Printf("Listening for remote clients on %s...\n", listener.Addr())
 }
 
-func Create(socketPath string) (*UnixSocketMonitor, error) {
+func Create(socketPath string, mode os.FileMode, policy *auth.Policy) (*UnixSocketMonitor, error) {
 	if _, err := os.Stat(socketPath); err == nil {
 		if err := os.Remove(socketPath); err != nil {
 			return nil, err
@@ -53,44 +98,324 @@ func Create(socketPath string) (*UnixSocketMonitor, error) {
 		return nil, err
 	}
 
-	os.Chmod(socketPath, 0660)
+	if err := os.Chmod(socketPath, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
 
 	log.Printf("Daemon started. Listening on %s...\n", socketPath)
 
+	return newMonitor(socketPath, listener, policy), nil
+}
+
+// Adopt serves the control socket on a listener opened by someone else, e.g.
+// by systemd socket activation. The owner of the listener also owns its
+// permissions and removes the socket file.
+func Adopt(listener net.Listener, policy *auth.Policy) *UnixSocketMonitor {
+	socketPath := listener.Addr().String()
+	log.Printf("Daemon started. Listening on %s (socket activation)...\n", socketPath)
+	return newMonitor(socketPath, listener, policy)
+}
+
+func newMonitor(socketPath string, listener net.Listener, policy *auth.Policy) *UnixSocketMonitor {
 	return &UnixSocketMonitor{
 		SocketPath: socketPath,
 		listner:    listener,
-	}, nil
+		policy:     policy,
+		conns:      make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts connections until ctx is cancelled, then closes the listeners
+// and all open connections and waits for their handlers to return.
+func (u *UnixSocketMonitor) Serve(ctx context.Context, backend Backend) error {
+	go func() {
+		<-ctx.Done()
+		u.listner.Close()
+		if u.remote != nil {
+			u.remote.Close()
+		}
+		u.mu.Lock()
+		for conn := range u.conns {
+			conn.Close()
+		}
+		u.conns = nil
+		u.mu.Unlock()
+	}()
+	defer u.wg.Wait()
+
+	if u.remote != nil {
+		done := make(chan struct{})
+		defer func() { <-done }()
+		go func() {
+			defer close(done)
+			u.accept(ctx, u.remote, backend)
+		}()
+	}
+	u.accept(ctx, u.listner, backend)
+	return nil
 }
-func (u *UnixSocketMonitor) ServeQueries(provider storage.Storage) {
+
+func (u *UnixSocketMonitor) accept(ctx context.Context, listener net.Listener, backend Backend) {
 	for {
-		conn, err := u.listner.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Failed to accept connection: %v", err)
+			time.Sleep(acceptBackoff)
 			continue
 		}
-		go func(c net.Conn) {
-			defer c.Close()
+		if !u.track(conn) {
+			conn.Close()
+			return
+		}
+		go func() {
+			defer u.untrack(conn)
+			u.handle(conn, backend)
+		}()
+	}
+}
+
+// track registers an open connection, refusing it once shutdown started
+func (u *UnixSocketMonitor) track(conn net.Conn) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.conns == nil {
+		return false
+	}
+	u.conns[conn] = struct{}{}
+	u.wg.Add(1)
+	return true
+}
 
-			var request proces.Request
-			err := json.NewDecoder(c).Decode(&request)
-			if err != nil {
+func (u *UnixSocketMonitor) untrack(conn net.Conn) {
+	conn.Close()
+	u.mu.Lock()
+	delete(u.conns, conn)
+	u.mu.Unlock()
+	u.wg.Done()
+}
+
+func (u *UnixSocketMonitor) handle(c net.Conn, backend Backend) {
+	proto := protocol.NewConn(c)
+	c.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := proto.Accept(); err != nil {
+		log.Printf("Handshake failed: %v", err)
+		return
+	}
+	peer, err := u.policy.Identify(c)
+	if err != nil {
+		log.Printf("Refused connection: %v", err)
+		proto.Send(protocol.Failure(0, "%v", err))
+		return
+	}
+	for {
+		c.SetDeadline(time.Now().Add(idleTimeout))
+		var request protocol.Request
+		err := proto.Receive(&request)
+		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
 				log.Printf("Failed to decode request: %v", err)
-				return
+				proto.Send(protocol.Failure(0, "malformed request: %v", err))
 			}
-			var data any
-			switch request.Type {
-			case "cpu":
-				data = provider.GetCPUSnapshot()
-			case "gpu":
-				data = provider.GetGPUSnapshot()
-			default:
-				json.NewEncoder(c).Encode(map[string]string{"error": "unknown type"})
+			return
+		}
+		c.SetDeadline(time.Time{})
+		if request.Type == protocol.TypeSubscribe {
+			serveSubscription(c, proto, backend.Broker, request, u.allow(backend.Storage, peer))
+			return
+		}
+		response := u.answer(backend, peer, request)
+		c.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := proto.Send(response); err != nil {
+			log.Printf("Failed to encode and send: %v", err)
+			return
+		}
+	}
+}
+
+// register admits a job submitted by the peer. Only admitted jobs reach the
+// daemon, the client is told the reason of a rejection.
+func (u *UnixSocketMonitor) register(backend Backend, peer auth.Peer, request protocol.Request) protocol.Response {
+	if request.Process == nil {
+		return protocol.Failure(request.ID, "%q request without a process", protocol.TypeRegister)
+	}
+	proc := *request.Process
+	proc.UID = peer.UID
+	proc.User = peer.User
+	if err := u.policy.CheckOwner(peer, proc.PGID); err != nil {
+		log.Printf("Rejected job %s (PGID %d): %v", proc.Name, proc.PGID, err)
+		return protocol.Failure(request.ID, "%v", err)
+	}
+	if err := backend.Admit(&proc); err != nil {
+		log.Printf("Rejected job %s (PGID %d): %v", proc.Name, proc.PGID, err)
+		return protocol.Failure(request.ID, "%v", err)
+	}
+	response, err := protocol.Success(request.ID, proc)
+	if err != nil {
+		backend.Release(proc.PGID)
+		return protocol.Failure(request.ID, "failed to encode job: %v", err)
+	}
+	backend.Processes <- proc
+	log.Printf("Accepted job %s with ID %d (PGID %d) from %s", proc.Name, proc.ID, proc.PGID, proc.User)
+	return response
+}
+
+func (u *UnixSocketMonitor) answer(backend Backend, peer auth.Peer, request protocol.Request) protocol.Response {
+	if request.Type == protocol.TypeRegister {
+		return u.register(backend, peer, request)
+	}
+
+	if request.Cluster {
+		return u.gather(backend, peer, request)
+	}
+
+	provider := backend.Storage
+	jobs := provider.GetJobsSnapshot()
+	if request.Job != 0 {
+		job, ok := jobs[request.Job]
+		if !ok || !u.policy.CanSee(peer, job) {
+			return protocol.Failure(request.ID, "job %d not found", request.Job)
+		}
+	}
+
+	var data any
+	switch request.Type {
+	case protocol.TypeJobs:
+		data = auth.Visible(u.policy, peer, jobs, jobs)
+	case protocol.TypeProcesses:
+		data = provider.GetProcessSnapshot(request.Job)
+	case protocol.TypeSeries:
+		data = events.NewSamples(provider.GetSeries(request.Job))
+	case protocol.TypeCancel:
+		job := jobs[request.Job]
+		if peer.Remote {
+			return protocol.Failure(request.ID, "remote clients cannot cancel jobs")
+		}
+		if !u.policy.CanControl(peer, job) {
+			return protocol.Failure(request.ID, "job %d is owned by %s", job.PGID, job.User)
+		}
+		if err := backend.Controller.Cancel(request.Job); err != nil {
+			return protocol.Failure(request.ID, "%v", err)
+		}
+		log.Printf("Job %d cancelled by %s", job.PGID, peer.User)
+	case protocol.TypeReload:
+		if peer.Remote || !peer.Admin {
+			return protocol.Failure(request.ID, "only administrators may reload the daemon")
+		}
+		log.Printf("Reload requested by %s", peer.User)
+		changes, err := backend.Controller.Reload()
+		if err != nil {
+			return protocol.Failure(request.ID, "%v", err)
+		}
+		data = changes
+	case protocol.TypeExitDir:
+		if !u.policy.Local(peer) {
+			return protocol.Failure(request.ID, "jobs can only be registered over the local socket")
+		}
+		dir, err := backend.Controller.ExitDir(peer)
+		if err != nil {
+			return protocol.Failure(request.ID, "%v", err)
+		}
+		data = dir
+	default:
+		summaries, ok := provider.GetSummaries(request.Type)
+		if !ok {
+			return protocol.Failure(request.ID, "unknown request type %q", request.Type)
+		}
+		data = auth.Visible(u.policy, peer, jobs, summaries)
+	}
+	response, err := protocol.Success(request.ID, data)
+	if err != nil {
+		return protocol.Failure(request.ID, "failed to encode data: %v", err)
+	}
+	return response
+}
+
+// gather answers the request locally and on all peers of the cluster. Users
+// restricted to their own jobs see jobs of the same user name on the peers.
+func (u *UnixSocketMonitor) gather(backend Backend, peer auth.Peer, request protocol.Request) protocol.Response {
+	if !cluster.Queryable(request.Type) {
+		return protocol.Failure(request.ID, "%q requests cannot be sent to the cluster", request.Type)
+	}
+	var keep func(proces.Process) bool
+	if u.policy.Restricts(peer) {
+		keep = func(job proces.Process) bool { return job.User == peer.User }
+	}
+	local := request
+	local.Cluster = false
+	results := backend.Cluster.Query(request, u.answer(backend, peer, local), keep)
+	response, err := protocol.Success(request.ID, results)
+	if err != nil {
+		return protocol.Failure(request.ID, "failed to encode data: %v", err)
+	}
+	return response
+}
+
+// allow hides jobs the peer may not see from its subscription. Decisions are
+// cached as the owner of a job never changes, a job not yet in storage is
+// looked up again with its next event.
+func (u *UnixSocketMonitor) allow(provider storage.Storage, peer auth.Peer) func(int32) bool {
+	if !u.policy.Restricts(peer) {
+		return nil
+	}
+	visible := make(map[int32]bool)
+	return func(pgid int32) bool {
+		if decision, ok := visible[pgid]; ok {
+			return decision
+		}
+		job, ok := provider.GetJobsSnapshot()[pgid]
+		if !ok {
+			return false
+		}
+		visible[pgid] = u.policy.CanSee(peer, job)
+		return visible[pgid]
+	}
+}
+
+// serveSubscription streams events, each wrapped in a response to the
+// subscribe request, until the client disconnects or the broker is closed.
+// Events the client was too slow to receive are reported by a "dropped"
+// event carrying their count.
+func serveSubscription(c net.Conn, proto *protocol.Conn, broker *events.Broker, request protocol.Request, allow func(int32) bool) {
+	sub := broker.Subscribe(events.Filter{Types: request.Events, Job: request.Job, Allow: allow})
+	defer broker.Unsubscribe(sub)
+
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, c)
+		close(closed)
+	}()
+
+	send := func(event events.Event) bool {
+		response, err := protocol.Success(request.ID, event)
+		if err != nil {
+			return false
+		}
+		c.SetWriteDeadline(time.Now().Add(writeTimeout))
+		return proto.Send(response) == nil
+	}
+	for {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-sub.C:
+			if !ok {
 				return
 			}
-			err = json.NewEncoder(c).Encode(data)
-			if err != nil {
-				log.Printf("Failed to encode and send: %v", err)
+			if dropped := sub.Dropped(); dropped > 0 {
+				if !send(events.Event{Type: events.TypeDropped, Time: time.Now(), Dropped: dropped}) {
+					return
+				}
 			}
-		}(conn)
+			if !send(event) {
+				return
+			}
+		}
 	}
 }
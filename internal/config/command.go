@@ -0,0 +1,50 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var ConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Check daemon config files",
+}
+
+var ValidateCmd = &cobra.Command{
+	Use:   "validate <file>",
+	Short: "validate a config file the way the daemon does on start",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		_, config, err := Read(args[0])
+		if err != nil {
+			var joined interface{ Unwrap() []error }
+			if errors.As(err, &joined) {
+				for _, problem := range joined.Unwrap() {
+					fmt.Fprintln(os.Stderr, problem)
+				}
+			} else {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			os.Exit(1)
+		}
+		fmt.Printf("%s is valid, collectors: %s\n", args[0], strings.Join(config.Enabled(), ", "))
+	},
+}
+
+var PrintDefaultCmd = &cobra.Command{
+	Use:   "print-default",
+	Short: "print the default config, a starting point for a new config file",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Stdout.Write(Default)
+	},
+}
+
+func init() {
+	ConfigCmd.AddCommand(ValidateCmd)
+	ConfigCmd.AddCommand(PrintDefaultCmd)
+}
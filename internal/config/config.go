@@ -0,0 +1,453 @@
+package config
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Wesenheit/Skaldenmet/internal/collectors"
+
+	"github.com/spf13/viper"
+	"go.yaml.in/yaml/v3"
+)
+
+//go:embed default.yaml
+var Default []byte
+
+const (
+	defaultInterval = time.Second
+	defaultSize     = 10
+	defaultMaxAge   = 10 * time.Second
+)
+
+// Collector holds settings shared by all collectors
+type Collector struct {
+	Interval time.Duration `mapstructure:"interval"`
+	Size     int           `mapstructure:"size"`
+	MaxAge   time.Duration `mapstructure:"maxAge"`
+}
+
+type CPUCollector struct {
+	Collector `mapstructure:",squash"`
+	PSS       bool `mapstructure:"pss"`
+}
+
+type NVIDIACollector struct {
+	Collector `mapstructure:",squash"`
+	Extended  []string `mapstructure:"extended"`
+}
+
+type RAPLCollector struct {
+	Collector `mapstructure:",squash"`
+	Path      string `mapstructure:"path"`
+}
+
+type Storage struct {
+	Name     string        `mapstructure:"name"`
+	Size     int           `mapstructure:"size"`
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+type State struct {
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+type Sockets struct {
+	Dir  string `mapstructure:"dir"`
+	Mode string `mapstructure:"mode"`
+}
+
+type Subscribe struct {
+	Buffer int `mapstructure:"buffer"`
+}
+
+type Auth struct {
+	RestrictList bool     `mapstructure:"restrictList"`
+	AdminGroup   string   `mapstructure:"adminGroup"`
+	Nodes        []string `mapstructure:"nodes"`
+}
+
+type HTTP struct {
+	Listen  string `mapstructure:"listen"`
+	Control bool   `mapstructure:"control"`
+}
+
+type TLS struct {
+	Listen string `mapstructure:"listen"`
+	Cert   string `mapstructure:"cert"`
+	Key    string `mapstructure:"key"`
+	CA     string `mapstructure:"ca"`
+}
+
+type Peer struct {
+	Name    string `mapstructure:"name"`
+	Address string `mapstructure:"address"`
+}
+
+type Cluster struct {
+	Node    string        `mapstructure:"node"`
+	Timeout time.Duration `mapstructure:"timeout"`
+	Peers   []Peer        `mapstructure:"peers"`
+}
+
+// Config is the schema of the daemon configuration. Collectors are enabled
+// by their section, a missing section leaves the field nil.
+type Config struct {
+	Storage   Storage   `mapstructure:"storage"`
+	State     State     `mapstructure:"state"`
+	Sockets   Sockets   `mapstructure:"sockets"`
+	Subscribe Subscribe `mapstructure:"subscribe"`
+	Auth      Auth      `mapstructure:"auth"`
+	HTTP      HTTP      `mapstructure:"http"`
+	TLS       TLS       `mapstructure:"tls"`
+	Cluster   Cluster   `mapstructure:"cluster"`
+
+	CPUCollector     *CPUCollector    `mapstructure:"cpuCollector"`
+	NVIDIACollector  *NVIDIACollector `mapstructure:"nvidiaCollector"`
+	IOCollector      *Collector       `mapstructure:"ioCollector"`
+	NetworkCollector *Collector       `mapstructure:"networkCollector"`
+	RAPLCollector    *RAPLCollector   `mapstructure:"raplCollector"`
+
+	ExecCollectors []collectors.ExecSettings `mapstructure:"execCollectors"`
+}
+
+// validName restricts names of external collectors, they appear in keys of
+// their metrics
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Collectors are the names of all collector sections
+var Collectors = []string{"cpuCollector", "ioCollector", "networkCollector", "nvidiaCollector", "raplCollector"}
+
+// FieldError is a problem with a single setting
+type FieldError struct {
+	Key     string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Key + ": " + e.Message
+}
+
+// Read loads and validates a config file. Defaults of missing settings are
+// set on the returned viper, so code reading it directly sees them too. Every
+// problem found is reported, joined into the returned error.
+func Read(path string) (*viper.Viper, *Config, error) {
+	if path == "" {
+		return nil, nil, errors.New("Please provide a config file with --config")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading config file: %w", err)
+	}
+	// viper lower cases keys, the raw document tells apart a misspelled
+	// cpucollector from cpuCollector
+	settings := map[string]any{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return nil, nil, fmt.Errorf("Error reading config file: %w", err)
+	}
+	v := viper.New()
+	v.SetConfigType("yaml")
+	v.SetConfigFile(path)
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, nil, fmt.Errorf("Error reading config file: %w", err)
+	}
+	// an empty section enables its collector with default settings, viper
+	// drops keys without a value
+	for _, name := range Collectors {
+		if value, ok := settings[name]; ok && value == nil {
+			settings[name] = map[string]any{}
+			if err := v.MergeConfigMap(map[string]any{name: map[string]any{}}); err != nil {
+				return nil, nil, fmt.Errorf("Error reading config file: %w", err)
+			}
+		}
+	}
+	if err := applyDefaults(v); err != nil {
+		return nil, nil, err
+	}
+
+	errs := unknownKeys(settings, reflect.TypeFor[Config](), "")
+	config := &Config{}
+	if err := v.Unmarshal(config); err != nil {
+		var decoding interface{ Unwrap() []error }
+		if errors.As(err, &decoding) {
+			return nil, nil, errors.Join(append(errs, decoding.Unwrap()...)...)
+		}
+		return nil, nil, errors.Join(append(errs, fmt.Errorf("invalid config: %w", err))...)
+	}
+	if err := config.Validate(); err != nil {
+		errs = append(errs, err.(interface{ Unwrap() []error }).Unwrap()...)
+	}
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+	return v, config, nil
+}
+
+// applyDefaults sets defaults of default.yaml outside collector sections, a
+// default there would enable the collector. Collectors that are enabled get
+// a default interval, size and max age.
+func applyDefaults(v *viper.Viper) error {
+	defaults := viper.New()
+	defaults.SetConfigType("yaml")
+	if err := defaults.ReadConfig(bytes.NewReader(Default)); err != nil {
+		return fmt.Errorf("invalid default config: %w", err)
+	}
+	for _, key := range defaults.AllKeys() {
+		section, _, _ := strings.Cut(key, ".")
+		if !slices.ContainsFunc(Collectors, func(name string) bool { return strings.EqualFold(name, section) }) {
+			v.SetDefault(key, defaults.Get(key))
+		}
+	}
+	for _, name := range Collectors {
+		if v.InConfig(name) {
+			v.SetDefault(name+".interval", defaultInterval)
+			v.SetDefault(name+".size", defaultSize)
+			v.SetDefault(name+".maxAge", defaultMaxAge)
+		}
+	}
+	return nil
+}
+
+// fields maps keys to the fields of a struct type. Squashed structs
+// contribute their own fields.
+func fields(t reflect.Type) map[string]reflect.StructField {
+	found := make(map[string]reflect.StructField)
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("mapstructure")
+		if tag == ",squash" {
+			for key, inner := range fields(field.Type) {
+				found[key] = inner
+			}
+			continue
+		}
+		found[tag] = field
+	}
+	return found
+}
+
+func unknownKeys(settings map[string]any, t reflect.Type, prefix string) []error {
+	known := fields(t)
+	errs := []error{}
+	for key, value := range settings {
+		field, ok := known[key]
+		if !ok {
+			message := "unknown setting"
+			if suggestion := closest(key, known); suggestion != "" {
+				message += fmt.Sprintf(", did you mean %q?", suggestion)
+			}
+			errs = append(errs, &FieldError{Key: prefix + key, Message: message})
+			continue
+		}
+		name := prefix + key
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		switch nested := value.(type) {
+		case map[string]any:
+			if fieldType.Kind() == reflect.Struct {
+				errs = append(errs, unknownKeys(nested, fieldType, name+".")...)
+			}
+		case []any:
+			if fieldType.Kind() == reflect.Slice && fieldType.Elem().Kind() == reflect.Struct {
+				for i, item := range nested {
+					if entry, ok := item.(map[string]any); ok {
+						errs = append(errs, unknownKeys(entry, fieldType.Elem(), fmt.Sprintf("%s[%d].", name, i))...)
+					}
+				}
+			}
+		}
+	}
+	slices.SortFunc(errs, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+	return errs
+}
+
+// closest suggests the known setting a misspelled key was meant to be
+func closest(key string, known map[string]reflect.StructField) string {
+	best, bestDistance := "", 3
+	for _, candidate := range slices.Sorted(maps.Keys(known)) {
+		if distance := levenshtein(strings.ToLower(key), strings.ToLower(candidate)); distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func positive[T int | time.Duration](errs *[]error, key string, value T) {
+	if value <= 0 {
+		*errs = append(*errs, &FieldError{Key: key, Message: "must be positive"})
+	}
+}
+
+func notNegative[T int | time.Duration](errs *[]error, key string, value T) {
+	if value < 0 {
+		*errs = append(*errs, &FieldError{Key: key, Message: "must not be negative"})
+	}
+}
+
+func (c *Collector) validate(errs *[]error, name string) {
+	positive(errs, name+".interval", c.Interval)
+	positive(errs, name+".size", c.Size)
+	positive(errs, name+".maxAge", c.MaxAge)
+}
+
+// exists reports a file setting pointing nowhere
+func exists(errs *[]error, key, path string) {
+	if path == "" {
+		*errs = append(*errs, &FieldError{Key: key, Message: "is required"})
+	} else if _, err := os.Stat(path); err != nil {
+		*errs = append(*errs, &FieldError{Key: key, Message: err.Error()})
+	}
+}
+
+// Enabled lists the sections of enabled collectors
+func (c *Config) Enabled() []string {
+	enabled := []string{}
+	for name, on := range map[string]bool{
+		"cpuCollector":     c.CPUCollector != nil,
+		"nvidiaCollector":  c.NVIDIACollector != nil,
+		"ioCollector":      c.IOCollector != nil,
+		"networkCollector": c.NetworkCollector != nil,
+		"raplCollector":    c.RAPLCollector != nil,
+	} {
+		if on {
+			enabled = append(enabled, name)
+		}
+	}
+	for _, settings := range c.ExecCollectors {
+		enabled = append(enabled, "execCollectors."+settings.Name)
+	}
+	slices.Sort(enabled)
+	return enabled
+}
+
+// Validate checks every setting without touching the machine, except for
+// files, groups and addresses the daemon would fail to use.
+func (c *Config) Validate() error {
+	errs := []error{}
+
+	if c.Storage.Name != "memory" {
+		errs = append(errs, &FieldError{Key: "storage.name", Message: fmt.Sprintf("unknown storage %q, only \"memory\" is supported", c.Storage.Name)})
+	}
+	positive(&errs, "storage.size", c.Storage.Size)
+	positive(&errs, "storage.interval", c.Storage.Interval)
+	positive(&errs, "state.interval", c.State.Interval)
+	positive(&errs, "subscribe.buffer", c.Subscribe.Buffer)
+	if mode, err := strconv.ParseUint(c.Sockets.Mode, 8, 32); err != nil || mode > 0777 {
+		errs = append(errs, &FieldError{Key: "sockets.mode", Message: fmt.Sprintf("%q is not an octal file mode", c.Sockets.Mode)})
+	}
+
+	if c.Auth.AdminGroup != "" {
+		if _, err := user.LookupGroup(c.Auth.AdminGroup); err != nil {
+			errs = append(errs, &FieldError{Key: "auth.adminGroup", Message: err.Error()})
+		}
+	}
+	if listen := c.HTTP.Listen; listen != "" && !strings.HasPrefix(listen, "unix:") {
+		if _, _, err := net.SplitHostPort(listen); err != nil {
+			errs = append(errs, &FieldError{Key: "http.listen", Message: "expected host:port or unix:/path"})
+		}
+	}
+
+	if c.TLS.Listen != "" || len(c.Cluster.Peers) > 0 {
+		exists(&errs, "tls.cert", c.TLS.Cert)
+		exists(&errs, "tls.key", c.TLS.Key)
+		exists(&errs, "tls.ca", c.TLS.CA)
+	}
+	if c.TLS.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.TLS.Listen); err != nil {
+			errs = append(errs, &FieldError{Key: "tls.listen", Message: "expected host:port"})
+		}
+	}
+	positive(&errs, "cluster.timeout", c.Cluster.Timeout)
+	names := map[string]bool{c.Cluster.Node: c.Cluster.Node != ""}
+	for i, peer := range c.Cluster.Peers {
+		key := fmt.Sprintf("cluster.peers[%d]", i)
+		if peer.Name == "" {
+			errs = append(errs, &FieldError{Key: key + ".name", Message: "is required"})
+		} else if names[peer.Name] {
+			errs = append(errs, &FieldError{Key: key + ".name", Message: fmt.Sprintf("node name %q used twice", peer.Name)})
+		}
+		names[peer.Name] = true
+		if peer.Address == "" {
+			errs = append(errs, &FieldError{Key: key + ".address", Message: "is required"})
+		}
+	}
+
+	if c.CPUCollector != nil {
+		c.CPUCollector.validate(&errs, "cpuCollector")
+	}
+	if c.NVIDIACollector != nil {
+		c.NVIDIACollector.validate(&errs, "nvidiaCollector")
+		for _, field := range c.NVIDIACollector.Extended {
+			if field != "all" && !slices.Contains(collectors.NVIDIAExtendedFields, field) {
+				errs = append(errs, &FieldError{Key: "nvidiaCollector.extended", Message: fmt.Sprintf("unknown field %q, expected \"all\" or one of %s", field, strings.Join(collectors.NVIDIAExtendedFields, ", "))})
+			}
+		}
+	}
+	if c.IOCollector != nil {
+		c.IOCollector.validate(&errs, "ioCollector")
+	}
+	if c.NetworkCollector != nil {
+		c.NetworkCollector.validate(&errs, "networkCollector")
+	}
+	if c.RAPLCollector != nil {
+		c.RAPLCollector.validate(&errs, "raplCollector")
+	}
+	execs := make(map[string]bool)
+	for i, settings := range c.ExecCollectors {
+		key := fmt.Sprintf("execCollectors[%d]", i)
+		if !validName.MatchString(settings.Name) {
+			errs = append(errs, &FieldError{Key: key + ".name", Message: fmt.Sprintf("%q is not a name of letters, digits, - and _", settings.Name)})
+		} else if execs[settings.Name] {
+			errs = append(errs, &FieldError{Key: key + ".name", Message: fmt.Sprintf("collector name %q used twice", settings.Name)})
+		}
+		execs[settings.Name] = true
+		if len(settings.Command) == 0 {
+			errs = append(errs, &FieldError{Key: key + ".command", Message: "is required"})
+		} else if _, err := exec.LookPath(settings.Command[0]); err != nil {
+			errs = append(errs, &FieldError{Key: key + ".command", Message: err.Error()})
+		}
+		// unset values take the defaults of the collector
+		notNegative(&errs, key+".interval", settings.Interval)
+		notNegative(&errs, key+".size", settings.Size)
+		notNegative(&errs, key+".maxAge", settings.MaxAge)
+		notNegative(&errs, key+".timeout", settings.Timeout)
+	}
+	if len(c.Enabled()) == 0 {
+		errs = append(errs, errors.New("no collector is enabled, add at least one collector section"))
+	}
+	return errors.Join(errs...)
+}
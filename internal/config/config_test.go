@@ -0,0 +1,131 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func read(t *testing.T, document string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(document), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, config, err := Read(path)
+	return config, err
+}
+
+func TestReadErrors(t *testing.T) {
+	cases := []struct {
+		name     string
+		document string
+		errors   []string
+	}{
+		{"valid", "cpuCollector:\n  interval: 2s\n", nil},
+		{"unknown section", "cpuCollectr:\n  interval: 1s\n", []string{`cpuCollectr: unknown setting, did you mean "cpuCollector"?`, "no collector is enabled"}},
+		{"wrong case", "cpucollector:\n", []string{`cpucollector: unknown setting, did you mean "cpuCollector"?`}},
+		{"unknown nested key", "cpuCollector:\n  intervl: 1s\n", []string{`cpuCollector.intervl: unknown setting, did you mean "interval"?`}},
+		{"no suggestion", "cpuCollector:\nbananas: 1\n", []string{"bananas: unknown setting"}},
+		{"unknown key of a list entry", "cpuCollector:\ncluster:\n  peers:\n    - name: ws2\n      adress: ws2:7420\n", []string{`cluster.peers[0].adress: unknown setting, did you mean "address"?`, "cluster.peers[0].address: is required"}},
+		{"no collector", "storage:\n  size: 10\n", []string{"no collector is enabled"}},
+		{"not positive", "cpuCollector:\n  size: 0\n  interval: -1s\n", []string{"cpuCollector.size: must be positive", "cpuCollector.interval: must be positive"}},
+		{"unknown storage", "cpuCollector:\nstorage:\n  name: disk\n", []string{`storage.name: unknown storage "disk"`}},
+		{"socket mode", "cpuCollector:\nsockets:\n  mode: \"999\"\n", []string{`sockets.mode: "999" is not an octal file mode`}},
+		{"http listen", "cpuCollector:\nhttp:\n  listen: localhost\n", []string{"http.listen: expected host:port or unix:/path"}},
+		{"tls files", "cpuCollector:\ntls:\n  listen: 0.0.0.0:7420\n", []string{"tls.cert: is required", "tls.key: is required", "tls.ca: is required"}},
+		{"peer twice", "cpuCollector:\ncluster:\n  node: ws1\n  peers:\n    - {name: ws1, address: a:1}\n", []string{`cluster.peers[0].name: node name "ws1" used twice`}},
+		{"nvidia fields", "nvidiaCollector:\n  extended: [clocks, colour]\n", []string{`nvidiaCollector.extended: unknown field "colour"`}},
+		{"exec name", "execCollectors:\n  - name: \"a b\"\n    command: [\"true\"]\n", []string{`execCollectors[0].name: "a b" is not a name`}},
+		{"exec command", "execCollectors:\n  - name: x\n    command: [\"/no/such/collector\"]\n", []string{"execCollectors[0].command:"}},
+		{"bad duration", "cpuCollector:\n  interval: soon\n", []string{"interval"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := read(t, c.document)
+			if len(c.errors) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("no error, want %q", c.errors)
+			}
+			for _, want := range c.errors {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadDefaults(t *testing.T) {
+	config, err := read(t, "ioCollector:\n  size: 3\nnetworkCollector:\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Storage.Name != "memory" || config.Storage.Size != 10000 || config.Storage.Interval != time.Second {
+		t.Errorf("storage %+v, want the defaults of default.yaml", config.Storage)
+	}
+	if config.State.Interval != 2*time.Second || config.Sockets.Mode != "0660" || config.Subscribe.Buffer != 256 || config.Cluster.Timeout != 5*time.Second {
+		t.Errorf("state, sockets, subscribe or cluster miss their defaults: %+v", config)
+	}
+	want := Collector{Interval: defaultInterval, Size: 3, MaxAge: defaultMaxAge}
+	if config.IOCollector == nil || *config.IOCollector != want {
+		t.Errorf("ioCollector %+v, want %+v", config.IOCollector, want)
+	}
+	// collectors of default.yaml are only enabled by their own section
+	if config.CPUCollector != nil {
+		t.Errorf("cpuCollector %+v enabled by default.yaml", config.CPUCollector)
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	cases := []struct {
+		name     string
+		document string
+		enabled  []string
+	}{
+		{"sections with settings", "cpuCollector:\n  size: 1\nraplCollector:\n  path: /sys\n", []string{"cpuCollector", "raplCollector"}},
+		{"empty sections", "ioCollector:\nnetworkCollector: {}\nnvidiaCollector:\n", []string{"ioCollector", "networkCollector", "nvidiaCollector"}},
+		{"exec collectors", "execCollectors:\n  - name: b\n    command: [\"true\"]\n  - name: a\n    command: [\"true\"]\n", []string{"execCollectors.a", "execCollectors.b"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config, err := read(t, c.document)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := config.Enabled(); !reflect.DeepEqual(got, c.enabled) {
+				t.Errorf("enabled %v, want %v", got, c.enabled)
+			}
+		})
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"size", "", 4},
+		{"size", "size", 0},
+		{"intervl", "interval", 1},
+		{"maxage", "maxAge", 1},
+		{"kitten", "sitting", 3},
+	}
+	for _, c := range cases {
+		if got := levenshtein(c.a, c.b); got != c.distance {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", c.a, c.b, got, c.distance)
+		}
+		if got := levenshtein(c.b, c.a); got != c.distance {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", c.b, c.a, got, c.distance)
+		}
+	}
+}
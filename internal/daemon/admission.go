@@ -0,0 +1,114 @@
+package daemon
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"syscall"
+
+	"github.com/Wesenheit/Skaldenmet/internal/auth"
+	"github.com/Wesenheit/Skaldenmet/internal/metrics"
+	"github.com/Wesenheit/Skaldenmet/internal/proces"
+	"github.com/Wesenheit/Skaldenmet/internal/sockets"
+
+	"github.com/shirou/gopsutil/v4/mem"
+)
+
+// admit decides whether a submitted job is monitored. Accepted jobs get an
+// ID unique within the lifetime of the daemon and their process group is
+// claimed, so a second registration of the same group is refused.
+func (d *Daemon) admit(proc *proces.Process) error {
+	if err := proc.Validate(); err != nil {
+		return err
+	}
+	if pgid, err := syscall.Getpgid(int(proc.PGID)); err != nil || int32(pgid) != proc.PGID {
+		return fmt.Errorf("process group %d does not exist", proc.PGID)
+	}
+	if cores := runtime.NumCPU(); proc.Cpus > cores {
+		return fmt.Errorf("requested %d cpus but the machine has %d", proc.Cpus, cores)
+	}
+	if proc.Memory > 0 {
+		if vm, err := mem.VirtualMemory(); err == nil && proc.Memory > vm.Total {
+			return fmt.Errorf("requested %s of memory but the machine has %s", metrics.FormatBytes(float64(proc.Memory)), metrics.FormatBytes(float64(vm.Total)))
+		}
+	}
+	if err := d.manager.Claim(proc.PGID); err != nil {
+		return err
+	}
+	// the path sent by the client is never read, a job shell writes its
+	// status to the directory of the daemon
+	if proc.ExitFile != "" {
+		proc.ExitFile = sockets.ExitFile(sockets.UserExits(d.exits, proc.UID), proc.PGID)
+	}
+
+	proc.ID = d.nextJobID.Add(1)
+	proc.State = proces.StateRunning
+	return nil
+}
+
+// Submit launches a job on behalf of a client. The job runs as the client and
+// is owned by it, a daemon not running as root only launches jobs of its own
+// user. The daemon is the parent of the job and takes the exit status from
+// waiting for it.
+func (d *Daemon) Submit(spec proces.Spec, owner auth.Peer) (proces.Process, error) {
+	if owner.Remote || owner.Anonymous {
+		return proces.Process{}, fmt.Errorf("jobs of %s cannot be submitted", owner.User)
+	}
+	var account *proces.Account
+	if owner.UID != uint32(os.Getuid()) {
+		if os.Getuid() != 0 {
+			return proces.Process{}, fmt.Errorf("the daemon cannot run jobs as %s", owner.User)
+		}
+		var err error
+		if account, err = proces.LookupAccount(owner.UID); err != nil {
+			return proces.Process{}, fmt.Errorf("failed to look up %s: %w", owner.User, err)
+		}
+	}
+	proc, cmd, err := proces.Launch(spec, "", account)
+	if err != nil {
+		return proc, err
+	}
+	log.Printf("Started command %s with PPID %d for %s", proc.Name, proc.PGID, owner.User)
+	proc.UID = owner.UID
+	proc.User = owner.User
+
+	// the leader is only reaped once the job is admitted, until then its
+	// group cannot vanish or be reused
+	if err := d.admit(&proc); err != nil {
+		syscall.Kill(-int(proc.PGID), syscall.SIGTERM)
+		go cmd.Wait()
+		return proc, err
+	}
+	d.manager.Await(proc.PGID)
+	go func() {
+		proces.WaitExited(cmd.Process.Pid)
+		d.manager.Exited(proc.PGID, func() (int, bool) {
+			cmd.Wait()
+			return proces.ExitStatus(cmd.ProcessState)
+		})
+	}()
+	d.processChan <- proc
+	return proc, nil
+}
+
+// ExitDir prepares the exit directory of owner, a daemon not running as root
+// only keeps exit files of its own user
+func (d *Daemon) ExitDir(owner auth.Peer) (string, error) {
+	if owner.Remote || owner.Anonymous {
+		return "", fmt.Errorf("exit files of %s are not kept", owner.User)
+	}
+	if os.Getuid() != 0 && owner.UID != uint32(os.Getuid()) {
+		return "", fmt.Errorf("the daemon cannot keep exit files of %s", owner.User)
+	}
+	return sockets.PrepareUserExits(d.exits, owner.UID, owner.GID)
+}
+
+// Cancel terminates all processes of a monitored job
+func (d *Daemon) Cancel(pgid int32) error {
+	if err := d.manager.Signal(pgid, syscall.SIGTERM); err != nil {
+		return fmt.Errorf("failed to cancel job %d: %w", pgid, err)
+	}
+	log.Printf("Cancelled job with PGID %d", pgid)
+	return nil
+}
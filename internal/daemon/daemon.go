@@ -3,15 +3,27 @@ package daemon
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/Wesenheit/Skaldenmet/internal/api"
+	"github.com/Wesenheit/Skaldenmet/internal/auth"
+	"github.com/Wesenheit/Skaldenmet/internal/client"
+	"github.com/Wesenheit/Skaldenmet/internal/cluster"
+	"github.com/Wesenheit/Skaldenmet/internal/config"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"github.com/Wesenheit/Skaldenmet/internal/collectors"
 	"github.com/Wesenheit/Skaldenmet/internal/comm"
+	"github.com/Wesenheit/Skaldenmet/internal/events"
 	"github.com/Wesenheit/Skaldenmet/internal/metrics"
 	"github.com/Wesenheit/Skaldenmet/internal/proces"
+	"github.com/Wesenheit/Skaldenmet/internal/remote"
+	"github.com/Wesenheit/Skaldenmet/internal/sockets"
 	"github.com/Wesenheit/Skaldenmet/internal/storage"
+	"github.com/Wesenheit/Skaldenmet/internal/systemd"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -20,12 +32,24 @@ import (
 )
 
 type Daemon struct {
-	reciver    comm.CommManager
-	server     comm.CommManager
-	collectors []collectors.Collector
-	storage    storage.Storage
-	wg         sync.WaitGroup
-	manager    *StateManager
+	control    comm.CommManager
+	collectors map[string]*collectorRun
+	// config is the configuration the daemon runs with, replaced on reload
+	config    *viper.Viper
+	reloads   chan chan reloadResult
+	stopping  chan struct{}
+	storage   storage.Storage
+	wg        sync.WaitGroup
+	manager   *StateManager
+	broker    *events.Broker
+	nextJobID atomic.Uint64
+	api       *api.Server
+	cluster   *cluster.Cluster
+	notifier  *systemd.Notifier
+	// exits is the only directory exit files of registered jobs are read from
+	exits string
+	// processChan carries registered jobs, set once Start is called
+	processChan chan proces.Process
 }
 
 var NameFunMapping = map[string]func(v *viper.Viper) (collectors.Collector, error){
@@ -35,45 +59,98 @@ var NameFunMapping = map[string]func(v *viper.Viper) (collectors.Collector, erro
 	"nvidiaCollector": func(v *viper.Viper) (collectors.Collector, error) {
 		return collectors.NewNVIDIAMonitor(v)
 	},
+	"ioCollector": func(v *viper.Viper) (collectors.Collector, error) {
+		return collectors.NewIOCollector(v)
+	},
+	"networkCollector": func(v *viper.Viper) (collectors.Collector, error) {
+		return collectors.NewNetworkCollector(v)
+	},
+	"raplCollector": func(v *viper.Viper) (collectors.Collector, error) {
+		return collectors.NewRAPLCollector(v)
+	},
 }
 
-func startCollector(v *viper.Viper, name string, start func(v *viper.Viper) (collectors.Collector, error), collectorList []collectors.Collector) []collectors.Collector {
-	mapViper := v.GetStringMapString(name)
-	if len(mapViper) > 0 {
-		Collector, err := start(v)
-		if err != nil {
-			log.Printf("Error %s: %v", Collector.Name(), err)
-		} else {
-			collectorList = append(collectorList, Collector)
-			log.Printf("Enabled %s", Collector.Name())
+// collectorSpec is an enabled collector, settings tell a reload whether it
+// has to be recreated
+type collectorSpec struct {
+	settings any
+	start    func() (collectors.Collector, error)
+}
+
+// enabledCollectors lists the collectors enabled by v keyed by their config
+// section, external collectors by "execCollectors.<name>"
+func enabledCollectors(v *viper.Viper) (map[string]collectorSpec, error) {
+	specs := make(map[string]collectorSpec)
+	for name, start := range NameFunMapping {
+		if v.InConfig(name) {
+			specs[name] = collectorSpec{
+				settings: v.Get(name),
+				start:    func() (collectors.Collector, error) { return start(v) },
+			}
 		}
 	}
-	return collectorList
+	execs, err := collectors.ExecCollectors(v)
+	if err != nil {
+		return nil, err
+	}
+	for _, settings := range execs {
+		specs["execCollectors."+settings.Name] = collectorSpec{
+			settings: settings,
+			start:    func() (collectors.Collector, error) { return collectors.NewExecCollector(settings) },
+		}
+	}
+	return specs, nil
 }
-func getCollectors(v *viper.Viper) ([]collectors.Collector, error) {
-	collectorList := []collectors.Collector{}
 
-	for name, function := range NameFunMapping {
-		collectorList = startCollector(v, name, function, collectorList)
+// getCollectors creates the enabled collectors keyed by their config section
+func getCollectors(v *viper.Viper) (map[string]*collectorRun, error) {
+	specs, err := enabledCollectors(v)
+	if err != nil {
+		return nil, err
+	}
+	runs := make(map[string]*collectorRun)
+	for name, spec := range specs {
+		collector, err := spec.start()
+		if err != nil {
+			log.Printf("Error %s: %v", name, err)
+			continue
+		}
+		runs[name] = &collectorRun{collector: collector, settings: spec.settings}
+		log.Printf("Enabled %s", collector.Name())
 	}
 
-	if len(collectorList) == 0 {
+	if len(runs) == 0 {
 		return nil, errors.New("No collectors")
 	}
-	return collectorList, nil
+	return runs, nil
 }
 
 func NewDaemon(v *viper.Viper) (*Daemon, error) {
-	reciver_handle, err := comm.Create("/tmp/skald.socket")
+	notifier := systemd.NewNotifier()
+	policy, err := auth.NewPolicy(v)
+	if err != nil {
+		return nil, err
+	}
+
+	socketDir := sockets.DaemonDir(v)
+	socketMode, err := sockets.Mode(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := sockets.Prepare(socketDir); err != nil {
+		return nil, err
+	}
+
+	nodes, err := cluster.New(v)
 	if err != nil {
 		return nil, err
 	}
 
-	server_handle, err := comm.Create("/tmp/skald_serve.socket")
+	control_handle, err := listen(v, policy, sockets.Control(socketDir), socketMode)
 	if err != nil {
 		return nil, err
 	}
-	collectorList, err := getCollectors(v)
+	runs, err := getCollectors(v)
 	if err != nil {
 		return nil, err
 	}
@@ -87,65 +164,233 @@ func NewDaemon(v *viper.Viper) (*Daemon, error) {
 	if err != nil {
 		return nil, err
 	}
+	buffer := v.GetInt("subscribe.buffer")
+	if buffer <= 0 {
+		buffer = 256
+	}
 	daemon := &Daemon{
-		reciver:    reciver_handle,
-		server:     server_handle,
-		collectors: collectorList,
+		control:    control_handle,
+		collectors: runs,
+		config:     v,
+		reloads:    make(chan chan reloadResult),
+		stopping:   make(chan struct{}),
 		manager:    state,
 		storage:    store,
+		broker:     events.NewBroker(buffer),
+		cluster:    nodes,
+		notifier:   notifier,
+		exits:      sockets.Exits(socketDir),
+	}
+	daemon.api, err = api.NewServer(v, store, daemon, policy)
+	if err != nil {
+		return nil, err
 	}
 	return daemon, nil
 }
 
-func (d *Daemon) Finalize(ctx context.Context) {
-	err := d.reciver.Finalize()
+// listen opens the control socket and the optional TLS listener. Sockets
+// passed by systemd socket activation are adopted instead, the control socket
+// is recognised by its path or the name "control", the TLS listener by the
+// name "tls".
+func listen(v *viper.Viper, policy *auth.Policy, socketPath string, socketMode os.FileMode) (*comm.UnixSocketMonitor, error) {
+	activated, err := systemd.Activated()
 	if err != nil {
-		log.Printf("Error during finalization: %s", err)
+		return nil, err
 	}
-	err = d.server.Finalize()
+	defer func() {
+		for _, listener := range activated {
+			log.Printf("Ignoring socket %q passed by systemd", listener.Name)
+		}
+		activated.Close()
+	}()
+
+	var control *comm.UnixSocketMonitor
+	if listener := activated.Take("control", socketPath); listener != nil {
+		control = comm.Adopt(listener, policy)
+	} else {
+		control, err = comm.Create(socketPath, socketMode, policy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	address := v.GetString("tls.listen")
+	listener := activated.Take("tls", "")
+	if address == "" && listener == nil {
+		return control, nil
+	}
+	config, err := remote.DaemonFiles(v).ServerConfig()
 	if err != nil {
-		log.Printf("Error during finalization: %s", err)
+		if listener != nil {
+			listener.Close()
+		}
+		control.Finalize()
+		return nil, fmt.Errorf("tls: %w", err)
 	}
+	if listener != nil {
+		control.AcceptTLS(listener, config)
+	} else if err := control.ListenTLS(address, config); err != nil {
+		control.Finalize()
+		return nil, err
+	}
+	return control, nil
+}
 
+func (d *Daemon) Finalize(ctx context.Context) {
+	err := d.control.Finalize()
+	if err != nil {
+		log.Printf("Error during finalization: %s", err)
+	}
+	if d.api != nil {
+		// ctx is already cancelled on shutdown, give requests in flight a moment
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := d.api.Finalize(shutdownCtx); err != nil {
+			log.Printf("Error during finalization: %s", err)
+		}
+	}
+	d.broker.Close()
 }
 
 func (d *Daemon) Start(ctx context.Context) error {
 	processChan := make(chan proces.Process, 100)
+	d.processChan = processChan
 	procStoreChan := make(chan proces.Process, 100)
 	pidChan := make(chan int32, 100)
+	jobChan := make(chan proces.JobEvent, 100)
+	eventChan := make(chan proces.JobEvent, 100)
+	sampleChan := make(chan []metrics.Metric, 100)
 	storageChan := make(chan []metrics.Metric, 100)
 
-	go d.reciver.StartListening(processChan)
-	go d.server.ServeQueries(d.storage)
+	// the sockets outlive ctx, they are closed last by shutdown
+	serveCtx, stopServing := context.WithCancel(context.Background())
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		err := d.control.Serve(serveCtx, comm.Backend{
+			Storage:    d.storage,
+			Broker:     d.broker,
+			Controller: d,
+			Admit:      d.admit,
+			Release:    d.manager.Release,
+			Processes:  processChan,
+			Cluster:    d.cluster,
+		})
+		if err != nil {
+			log.Printf("Control socket failed: %v", err)
+		}
+	}()
+	if d.api != nil {
+		go func() {
+			if err := d.api.Serve(); err != nil {
+				log.Printf("HTTP API failed: %v", err)
+			}
+		}()
+	}
+
+	managed := make(chan struct{})
+	go func() {
+		defer close(managed)
+		d.manager.Start(ctx, pidChan, jobChan)
+	}()
+	go runDispatcher(processChan, pidChan, procStoreChan, d.broker)
+	ended := make(chan struct{}, 1)
+	go runPublisher(d.broker, sampleChan, storageChan, jobChan, eventChan, ended)
+	stored := make(chan struct{})
+	go func() {
+		defer close(stored)
+		if err := d.storage.Store(ctx, procStoreChan, eventChan, storageChan); err != nil {
+			log.Printf("Error finalizing storage: %v", err)
+		}
+	}()
 
-	go d.manager.Start(ctx, pidChan)
-	go runDispatcher(processChan, pidChan, procStoreChan)
-	go d.storage.Store(ctx, procStoreChan, storageChan)
+	for name, run := range d.collectors {
+		d.collectors[name] = d.launch(ctx, run.collector, run.settings, sampleChan)
+	}
+	d.notifyReady()
 
-	for _, collector := range d.collectors {
-		d.wg.Add(1)
-		go d.RunCollector(ctx, collector, storageChan)
+	// the watchdog is fed by this loop, a daemon stuck in a reload is
+	// restarted by systemd
+	var watchdog <-chan time.Time
+	if interval := d.notifier.WatchdogInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		watchdog = ticker.C
 	}
 
-	<-ctx.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			// a reload arriving now would wait for this loop forever and
+			// keep the sockets from closing
+			close(d.stopping)
+			d.notifier.Stopping()
+			d.shutdown(sampleChan, jobChan, managed, stored)
+			stopServing()
+			<-served
+			return nil
+		case <-watchdog:
+			d.notifier.Watchdog()
+		case <-ended:
+			// samples of the job still sit in the buffers, send them so its
+			// summary is complete
+			for _, run := range d.collectors {
+				run.requestFlush()
+			}
+		case result := <-d.reloads:
+			d.notifier.Reloading()
+			changes, err := d.reload(ctx, sampleChan)
+			result <- reloadResult{changes: changes, err: err}
+			d.notifyReady()
+		}
+	}
+}
+
+// shutdown runs once ctx is cancelled. Collectors flush their buffers when
+// they stop, the publisher and storage drain everything sent before the
+// channels are closed, and collectors are finalized only after storage
+// aggregated the last samples.
+func (d *Daemon) shutdown(sampleChan chan []metrics.Metric, jobChan chan proces.JobEvent, managed, stored <-chan struct{}) {
 	d.wg.Wait()
-	return nil
+	close(sampleChan)
+	<-managed
+	close(jobChan)
+	<-stored
+	for _, run := range d.collectors {
+		run.finalize()
+	}
+}
+
+func (d *Daemon) notifyReady() {
+	if err := d.notifier.Ready(fmt.Sprintf("Monitoring with %d collectors", len(d.collectors))); err != nil {
+		log.Print(err)
+	}
 }
 
+// RunCollector samples at the interval of the collector. Its buffer is sent
+// when full, when the oldest sample may be older than the max age and when
+// flush is signalled after a job ended.
 func (d *Daemon) RunCollector(ctx context.Context, collector collectors.Collector,
-	storageChan chan []metrics.Metric,
+	storageChan chan []metrics.Metric, flush <-chan struct{},
 ) error {
 	interval := collector.Interval()
 	name := collector.Name()
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
+	aged := time.NewTicker(collector.MaxAge())
+	defer aged.Stop()
 	defer d.wg.Done()
 
 	for {
 		select {
 		case <-ctx.Done():
 			log.Printf("Stopping collector: %s", name)
+			collector.Flush(storageChan)
 			return nil
+		case <-aged.C:
+			collector.Flush(storageChan)
+		case <-flush:
+			collector.Flush(storageChan)
 		case <-ticker.C:
 			err := collector.Collect(storageChan, d.manager.GetSnapshot())
 			if err != nil {
@@ -162,16 +407,9 @@ var DaemonCmd = &cobra.Command{
 		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 		defer cancel()
 
-		if configFile == "" {
-			log.Fatal("Please provide a config file with --config")
-		}
-		v := viper.New()
-		v.SetConfigType("yaml")
-		v.SetConfigFile(configFile)
-
-		err := v.ReadInConfig()
+		v, err := readConfig(configFile)
 		if err != nil {
-			log.Fatalf("Error reading config file: %v", err)
+			log.Fatal(err)
 		}
 
 		daemon, err := NewDaemon(v)
@@ -180,6 +418,16 @@ var DaemonCmd = &cobra.Command{
 			return
 		}
 		defer daemon.Finalize(ctx)
+
+		hangup := make(chan os.Signal, 1)
+		signal.Notify(hangup, syscall.SIGHUP)
+		defer signal.Stop(hangup)
+		go func() {
+			for range hangup {
+				daemon.Reload()
+			}
+		}()
+
 		if err := daemon.Start(ctx); err != nil {
 			log.Printf("Daemon failed: %v", err)
 		}
@@ -187,8 +435,114 @@ var DaemonCmd = &cobra.Command{
 	},
 }
 
+// readConfig reads the config file, settings are validated and missing ones
+// are set to their defaults
+func readConfig(path string) (*viper.Viper, error) {
+	v, _, err := config.Read(path)
+	return v, err
+}
+
+var ReloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "make the running daemon re-read its config file, like SIGHUP",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := client.Connect()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer c.Close()
+
+		changes, err := c.Reload()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(changes) == 0 {
+			fmt.Println("Config reloaded, nothing changed")
+		}
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+	},
+}
+
+var InstallUnitCmd = &cobra.Command{
+	Use:   "install-unit",
+	Short: "write a systemd unit running the daemon with the given config",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		v, err := readConfig(configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		unit := systemd.Unit{User: unitUser}
+		if unit.Config, err = filepath.Abs(configFile); err != nil {
+			log.Fatal(err)
+		}
+		if unit.Executable, err = os.Executable(); err != nil {
+			log.Fatal(err)
+		}
+		if resolved, err := filepath.EvalSymlinks(unit.Executable); err == nil {
+			unit.Executable = resolved
+		}
+		if unitSocket {
+			unit.Socket = sockets.Control(sockets.DaemonDir(v))
+			if unit.SocketMode, err = sockets.Mode(v); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		files := map[string]string{systemd.ServiceName: unit.Service()}
+		if unitSocket {
+			files[systemd.SocketName] = unit.SocketUnit()
+		}
+		if unitPrint {
+			for _, name := range []string{systemd.ServiceName, systemd.SocketName} {
+				if content, ok := files[name]; ok {
+					fmt.Printf("# %s\n%s\n", name, content)
+				}
+			}
+			return
+		}
+
+		dir, err := systemd.Dir(unitUser)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatal(err)
+		}
+		for name, content := range files {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("Wrote %s\n", path)
+		}
+
+		systemctl := "systemctl"
+		if unitUser {
+			systemctl += " --user"
+		}
+		enable := systemd.ServiceName
+		if unitSocket {
+			enable = systemd.SocketName
+		}
+		fmt.Printf("Enable it with: %s daemon-reload && %s enable --now %s\n", systemctl, systemctl, enable)
+	},
+}
+
 var configFile string
+var unitUser bool
+var unitSocket bool
+var unitPrint bool
 
 func init() {
 	DaemonCmd.Flags().StringVarP(&configFile, "config", "c", "", "config file path")
+	DaemonCmd.AddCommand(ReloadCmd)
+	DaemonCmd.AddCommand(InstallUnitCmd)
+	InstallUnitCmd.Flags().StringVarP(&configFile, "config", "c", "", "config file the daemon is started with")
+	InstallUnitCmd.Flags().BoolVar(&unitUser, "user", false, "install a user unit instead of a system one")
+	InstallUnitCmd.Flags().BoolVar(&unitSocket, "socket", false, "also install a socket unit opening the control socket")
+	InstallUnitCmd.Flags().BoolVar(&unitPrint, "print", false, "print the units instead of writing them")
 }
@@ -0,0 +1,100 @@
+package daemon
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Wesenheit/Skaldenmet/internal/events"
+	"github.com/Wesenheit/Skaldenmet/internal/metrics"
+	"github.com/Wesenheit/Skaldenmet/internal/proces"
+)
+
+// alerter raises each kind of alert at most once per job
+type alerter struct {
+	raised map[int32]map[string]struct{}
+}
+
+func newAlerter() *alerter {
+	return &alerter{raised: make(map[int32]map[string]struct{})}
+}
+
+func (a *alerter) raise(broker *events.Broker, job int32, kind string, message string) {
+	if _, ok := a.raised[job]; !ok {
+		a.raised[job] = make(map[string]struct{})
+	}
+	if _, done := a.raised[job][kind]; done {
+		return
+	}
+	a.raised[job][kind] = struct{}{}
+	broker.Publish(events.Event{
+		Type:  events.TypeAlert,
+		Time:  time.Now(),
+		Alert: &events.Alert{Job: job, Kind: kind, Message: message},
+	})
+}
+
+func (a *alerter) checkSamples(broker *events.Broker, batch []metrics.Metric) {
+	for _, metric := range batch {
+		switch m := metric.(type) {
+		case *metrics.CPUMetric:
+			if m.Swap > 0 {
+				a.raise(broker, m.PPID, "swap", fmt.Sprintf("Process %d is swapping", m.Pid_id))
+			}
+		case *metrics.GPUMetric:
+			if m.EccUncorrected > 0 {
+				a.raise(broker, m.PPid(), "ecc", fmt.Sprintf("GPU %d reports %d uncorrected ECC errors", m.Device, m.EccUncorrected))
+			}
+			if reasons := m.ThrottleReasons & (metrics.ThrottleHwSlowdown | metrics.ThrottleHwThermalSlowdown | metrics.ThrottleHwPowerBrake); reasons != 0 {
+				a.raise(broker, m.PPid(), "throttle", fmt.Sprintf("GPU %d is throttled (%s)", m.Device, strings.Join(metrics.ThrottleReasonNames(reasons), ", ")))
+			}
+		}
+	}
+}
+
+func (a *alerter) checkJob(broker *events.Broker, event proces.JobEvent) {
+	switch event.State {
+	case proces.StateOrphaned:
+		a.raise(broker, event.PGID, "orphaned", "Job leader exited while children are still running")
+	case proces.StateFinished:
+		delete(a.raised, event.PGID)
+	}
+}
+
+// runPublisher forwards samples and job events to storage and publishes them
+// to subscribers. The broker never blocks, so storage sees the same
+// backpressure as without subscribers. Once both inputs are closed the
+// outputs are closed too, letting storage finish. A finished job is signalled
+// on ended without blocking.
+func runPublisher(broker *events.Broker, sampleChan <-chan []metrics.Metric, storageChan chan<- []metrics.Metric,
+	jobChan <-chan proces.JobEvent, eventChan chan<- proces.JobEvent, ended chan<- struct{}) {
+	defer close(storageChan)
+	defer close(eventChan)
+	alerts := newAlerter()
+	for sampleChan != nil || jobChan != nil {
+		select {
+		case batch, ok := <-sampleChan:
+			if !ok {
+				sampleChan = nil
+				continue
+			}
+			storageChan <- batch
+			broker.Publish(events.SamplesEvent(batch))
+			alerts.checkSamples(broker, batch)
+		case event, ok := <-jobChan:
+			if !ok {
+				jobChan = nil
+				continue
+			}
+			eventChan <- event
+			if event.State == proces.StateFinished {
+				select {
+				case ended <- struct{}{}:
+				default:
+				}
+			}
+			broker.Publish(events.Event{Type: events.TypeJob, Time: event.Time, Job: &event})
+			alerts.checkJob(broker, event)
+		}
+	}
+}
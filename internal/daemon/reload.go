@@ -0,0 +1,215 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"reflect"
+	"slices"
+
+	"github.com/Wesenheit/Skaldenmet/internal/collectors"
+	"github.com/Wesenheit/Skaldenmet/internal/config"
+	"github.com/Wesenheit/Skaldenmet/internal/metrics"
+
+	"github.com/spf13/viper"
+)
+
+// restartOnly lists settings read once when the daemon starts, a reload only
+// reports that they changed.
+var restartOnly = []string{"sockets", "tls", "cluster", "http", "auth", "subscribe", "storage.name", "storage.size"}
+
+// collectorRun is a collector started by the daemon
+type collectorRun struct {
+	collector collectors.Collector
+	// settings the collector was created with
+	settings any
+	cancel   context.CancelFunc
+	done     chan struct{}
+	// flush asks the collector to send its buffer right away
+	flush chan struct{}
+}
+
+type reloadResult struct {
+	changes []string
+	err     error
+}
+
+func (d *Daemon) launch(ctx context.Context, collector collectors.Collector, settings any, sampleChan chan []metrics.Metric) *collectorRun {
+	runCtx, cancel := context.WithCancel(ctx)
+	run := &collectorRun{
+		collector: collector,
+		settings:  settings,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		flush:     make(chan struct{}, 1),
+	}
+	d.wg.Add(1)
+	go func() {
+		defer close(run.done)
+		d.RunCollector(runCtx, collector, sampleChan, run.flush)
+	}()
+	return run
+}
+
+// requestFlush never blocks, a request already pending covers this one
+func (run *collectorRun) requestFlush() {
+	select {
+	case run.flush <- struct{}{}:
+	default:
+	}
+}
+
+// stop cancels the collector, its buffered samples are flushed before it is
+// finalized
+func (run *collectorRun) stop() {
+	run.cancel()
+	<-run.done
+	run.finalize()
+}
+
+func (run *collectorRun) finalize() {
+	if err := run.collector.Finalize(); err != nil {
+		log.Printf("Error finalizing %s: %v", run.collector.Name(), err)
+	}
+}
+
+// Reload re-reads the config file and applies it to the running daemon. It
+// returns a description of every change, settings that cannot change at
+// runtime are reported as well but keep their value until a restart.
+func (d *Daemon) Reload() ([]string, error) {
+	result := make(chan reloadResult, 1)
+	select {
+	case d.reloads <- result:
+	case <-d.stopping:
+		return nil, errors.New("daemon is not running")
+	}
+	reloaded := <-result
+	if reloaded.err != nil {
+		log.Printf("Reload failed: %v", reloaded.err)
+		return nil, reloaded.err
+	}
+	if len(reloaded.changes) == 0 {
+		log.Print("Reloaded config, nothing changed")
+	}
+	for _, change := range reloaded.changes {
+		log.Printf("Reload: %s", change)
+	}
+	return reloaded.changes, nil
+}
+
+// reload runs in the loop of Start. Everything is validated and new collectors
+// are created before anything is applied, a broken config leaves the daemon
+// as it was.
+func (d *Daemon) reload(ctx context.Context, sampleChan chan []metrics.Metric) ([]string, error) {
+	v, _, err := config.Read(d.config.ConfigFileUsed())
+	if err != nil {
+		return nil, err
+	}
+
+	refresh := v.GetDuration("state.interval")
+	interval := v.GetDuration("storage.interval")
+
+	specs, err := enabledCollectors(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(specs) == 0 {
+		return nil, errors.New("No collectors")
+	}
+	running := make(map[string]any, len(d.collectors))
+	for name, run := range d.collectors {
+		running[name] = run.settings
+	}
+	enabled := make(map[string]any, len(specs))
+	for name, spec := range specs {
+		enabled[name] = spec.settings
+	}
+	actions := collectorActions(running, enabled)
+
+	created := make(map[string]collectors.Collector)
+	for _, name := range slices.Sorted(maps.Keys(actions)) {
+		if actions[name] == stopped {
+			continue
+		}
+		collector, err := specs[name].start()
+		if err != nil {
+			for _, done := range created {
+				done.Finalize()
+			}
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		created[name] = collector
+	}
+
+	changes := []string{}
+	for _, name := range slices.Sorted(maps.Keys(actions)) {
+		if run, running := d.collectors[name]; running {
+			run.stop()
+		}
+		if collector, ok := created[name]; ok {
+			d.collectors[name] = d.launch(ctx, collector, specs[name].settings, sampleChan)
+		} else {
+			delete(d.collectors, name)
+		}
+		changes = append(changes, fmt.Sprintf("%s %s", actions[name], name))
+	}
+
+	if old := d.manager.Refresh(); old != refresh {
+		d.manager.SetRefresh(refresh)
+		changes = append(changes, fmt.Sprintf("state.interval %s -> %s", old, refresh))
+	}
+	if old := d.storage.Interval(); old != interval {
+		d.storage.SetInterval(interval)
+		changes = append(changes, fmt.Sprintf("storage.interval %s -> %s", old, interval))
+	}
+
+	changes = append(changes, restartChanges(d.config, v)...)
+	d.config = v
+	return changes, nil
+}
+
+// What a reload does to a collector
+const (
+	started      = "started"
+	stopped      = "stopped"
+	reconfigured = "reconfigured"
+)
+
+// collectorActions compares settings of the running collectors with settings
+// of the enabled ones, both keyed by config section. Collectors that are no
+// longer enabled are stopped, new ones started and those whose settings
+// changed reconfigured, unchanged collectors are left out.
+func collectorActions(running, enabled map[string]any) map[string]string {
+	actions := make(map[string]string)
+	for name, settings := range enabled {
+		old, ok := running[name]
+		switch {
+		case !ok:
+			actions[name] = started
+		case !reflect.DeepEqual(old, settings):
+			actions[name] = reconfigured
+		}
+	}
+	for name := range running {
+		if _, ok := enabled[name]; !ok {
+			actions[name] = stopped
+		}
+	}
+	return actions
+}
+
+// restartChanges reports settings of restartOnly that differ in v from the
+// running config. They are set back in v, which keeps describing the running
+// daemon, so later reloads report them again.
+func restartChanges(running, v *viper.Viper) []string {
+	changes := []string{}
+	for _, key := range restartOnly {
+		if !reflect.DeepEqual(running.Get(key), v.Get(key)) {
+			changes = append(changes, fmt.Sprintf("%s changed, restart the daemon to apply", key))
+			v.Set(key, running.Get(key))
+		}
+	}
+	return changes
+}
@@ -0,0 +1,110 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Wesenheit/Skaldenmet/internal/config"
+
+	"github.com/spf13/viper"
+)
+
+func read(t *testing.T, document string) *viper.Viper {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(document), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	v, _, err := config.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func settings(t *testing.T, document string) map[string]any {
+	t.Helper()
+	specs, err := enabledCollectors(read(t, document))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enabled := make(map[string]any, len(specs))
+	for name, spec := range specs {
+		enabled[name] = spec.settings
+	}
+	return enabled
+}
+
+func TestCollectorActions(t *testing.T) {
+	cases := []struct {
+		name    string
+		running string
+		enabled string
+		actions map[string]string
+	}{
+		{"unchanged", "cpuCollector:\n  interval: 1s\n", "cpuCollector:\n  interval: 1s\n", map[string]string{}},
+		{"started", "cpuCollector:\n", "cpuCollector:\nioCollector:\n", map[string]string{"ioCollector": started}},
+		{"stopped", "cpuCollector:\nioCollector:\n", "cpuCollector:\n", map[string]string{"ioCollector": stopped}},
+		{"reconfigured", "cpuCollector:\n  interval: 1s\n", "cpuCollector:\n  interval: 2s\n", map[string]string{"cpuCollector": reconfigured}},
+		{"empty section gets settings", "ioCollector:\n", "ioCollector:\n  size: 5\n", map[string]string{"ioCollector": reconfigured}},
+		{"other settings untouched", "cpuCollector:\nstate:\n  interval: 1s\n", "cpuCollector:\nstate:\n  interval: 3s\n", map[string]string{}},
+		{
+			"exec collectors by name",
+			"execCollectors:\n  - name: a\n    command: [\"true\"]\n  - name: b\n    command: [\"true\"]\n",
+			"execCollectors:\n  - name: b\n    command: [\"true\", \"-x\"]\n  - name: c\n    command: [\"true\"]\n",
+			map[string]string{"execCollectors.a": stopped, "execCollectors.b": reconfigured, "execCollectors.c": started},
+		},
+		{
+			"exec collectors reordered",
+			"execCollectors:\n  - name: a\n    command: [\"true\"]\n  - name: b\n    command: [\"true\"]\n",
+			"execCollectors:\n  - name: b\n    command: [\"true\"]\n  - name: a\n    command: [\"true\"]\n",
+			map[string]string{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actions := collectorActions(settings(t, c.running), settings(t, c.enabled))
+			if !reflect.DeepEqual(actions, c.actions) {
+				t.Errorf("actions %v, want %v", actions, c.actions)
+			}
+		})
+	}
+}
+
+func TestRestartChanges(t *testing.T) {
+	cases := []struct {
+		name    string
+		running string
+		loaded  string
+		changes []string
+	}{
+		{"nothing changed", "cpuCollector:\n", "cpuCollector:\n", []string{}},
+		{"applied on reload", "cpuCollector:\nstorage:\n  interval: 1s\n", "cpuCollector:\nstorage:\n  interval: 5s\n", []string{}},
+		{"storage size", "cpuCollector:\n", "cpuCollector:\nstorage:\n  size: 5\n", []string{"storage.size changed, restart the daemon to apply"}},
+		{
+			"several sections",
+			"cpuCollector:\n",
+			"cpuCollector:\nsockets:\n  mode: \"0600\"\nsubscribe:\n  buffer: 8\n",
+			[]string{"sockets changed, restart the daemon to apply", "subscribe changed, restart the daemon to apply"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			running, loaded := read(t, c.running), read(t, c.loaded)
+			changes := restartChanges(running, loaded)
+			if !reflect.DeepEqual(changes, c.changes) {
+				t.Fatalf("changes %q, want %q", changes, c.changes)
+			}
+			for _, key := range restartOnly {
+				if !reflect.DeepEqual(loaded.Get(key), running.Get(key)) {
+					t.Errorf("%s is %v after the reload, want the running %v", key, loaded.Get(key), running.Get(key))
+				}
+			}
+			if again := restartChanges(running, loaded); len(again) != 0 {
+				t.Errorf("second comparison reported %q", again)
+			}
+		})
+	}
+}
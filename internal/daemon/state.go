@@ -3,8 +3,10 @@ package daemon
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"maps"
+	"github.com/Wesenheit/Skaldenmet/internal/events"
 	"github.com/Wesenheit/Skaldenmet/internal/proces"
 	"sync"
 	"syscall"
@@ -19,6 +21,16 @@ type StateManager struct {
 	refresh  time.Duration
 	rootPIDs map[int32]struct{}
 	fullTree map[int32]int32
+	orphaned map[int32]struct{}
+	// awaited jobs were launched by the daemon, they finish once the daemon
+	// has waited for their leader and knows the exit status
+	awaited map[int32]struct{}
+	exits   map[int32]int
+	// reaped jobs lost their leader, their group may be reused and is
+	// never signalled again
+	reaped map[int32]struct{}
+	// retime passes a new refresh interval to the running loop
+	retime chan time.Duration
 }
 
 func NewState(v *viper.Viper) (*StateManager, error) {
@@ -30,44 +42,146 @@ func NewState(v *viper.Viper) (*StateManager, error) {
 		refresh:  duration,
 		rootPIDs: make(map[int32]struct{}),
 		fullTree: make(map[int32]int32),
+		orphaned: make(map[int32]struct{}),
+		awaited:  make(map[int32]struct{}),
+		exits:    make(map[int32]int),
+		reaped:   make(map[int32]struct{}),
+		retime:   make(chan time.Duration, 1),
 	}, nil
 }
-func runDispatcher(processChan <-chan proces.Process, pidChan chan<- int32, stateChan chan<- proces.Process) {
+func runDispatcher(processChan <-chan proces.Process, pidChan chan<- int32, stateChan chan<- proces.Process, broker *events.Broker) {
 	for proc := range processChan {
-		pidChan <- proc.PGID
 		if stateChan != nil {
 			stateChan <- proc
 		}
+		pidChan <- proc.PGID
+		broker.Publish(events.Event{
+			Type: events.TypeJob,
+			Time: proc.StartTime,
+			Job:  &proces.JobEvent{PGID: proc.PGID, State: proces.StateRunning, Time: proc.StartTime},
+		})
 	}
 }
-func (s *StateManager) Start(ctx context.Context, pidchan chan int32) {
-	ticker := time.NewTicker(s.refresh)
+func (s *StateManager) Start(ctx context.Context, pidchan chan int32, eventChan chan<- proces.JobEvent) {
+	ticker := time.NewTicker(s.Refresh())
 
 	for {
+		var events []proces.JobEvent
 		select {
 		case <-ctx.Done():
 			log.Print("Finalizing State managment")
 			return
+		case refresh := <-s.retime:
+			ticker.Reset(refresh)
 		case <-ticker.C:
-			s.RefreshTree()
+			events = s.RefreshTree()
 
 		case pid := <-pidchan:
 			s.AddRoot(pid)
-			s.RefreshTree()
+			events = s.RefreshTree()
+		}
+		for _, event := range events {
+			eventChan <- event
 		}
 	}
 }
 
+func (s *StateManager) Refresh() time.Duration {
+	s.RLock()
+	defer s.RUnlock()
+	return s.refresh
+}
+
+// SetRefresh changes how often the process tree is rebuilt, a running loop
+// picks up the new interval with its next iteration.
+func (s *StateManager) SetRefresh(refresh time.Duration) {
+	s.Lock()
+	s.refresh = refresh
+	s.Unlock()
+	select {
+	case <-s.retime:
+	default:
+	}
+	s.retime <- refresh
+}
+
+// Claim reserves a process group for a new job, a group can only be monitored
+// by a single job at a time.
+func (s *StateManager) Claim(pgid int32) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, tracked := s.rootPIDs[pgid]; tracked {
+		return fmt.Errorf("process group %d is already monitored", pgid)
+	}
+	s.rootPIDs[pgid] = struct{}{}
+	return nil
+}
+
+// Release gives up the claim of a job that was refused after all
+func (s *StateManager) Release(pgid int32) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.rootPIDs, pgid)
+	delete(s.awaited, pgid)
+	delete(s.reaped, pgid)
+}
+
+// Await holds back the end of a job launched by the daemon until Exited
+// reports its exit status
+func (s *StateManager) Await(pgid int32) {
+	s.Lock()
+	defer s.Unlock()
+	s.awaited[pgid] = struct{}{}
+}
+
+// Exited reaps the leader of an awaited job with reap and records its exit
+// status. Reaping holds the lock, so Signal never reaches a reused group.
+func (s *StateManager) Exited(pgid int32, reap func() (int, bool)) {
+	s.Lock()
+	defer s.Unlock()
+	code, known := reap()
+	if _, awaited := s.awaited[pgid]; !awaited {
+		return
+	}
+	delete(s.awaited, pgid)
+	s.reaped[pgid] = struct{}{}
+	if known {
+		s.exits[pgid] = code
+	}
+}
+
+// Signal sends sig to the process group of a monitored job. Groups of jobs
+// whose leader the daemon has reaped are not signalled, the kernel may
+// already have handed their ID to someone else.
+func (s *StateManager) Signal(pgid int32, sig syscall.Signal) error {
+	s.RLock()
+	defer s.RUnlock()
+	if _, tracked := s.rootPIDs[pgid]; !tracked {
+		return fmt.Errorf("job %d is not running", pgid)
+	}
+	if _, reaped := s.reaped[pgid]; reaped {
+		return fmt.Errorf("job %d has exited", pgid)
+	}
+	return syscall.Kill(-int(pgid), sig)
+}
+
+func (s *StateManager) Tracked(pgid int32) bool {
+	s.RLock()
+	defer s.RUnlock()
+	_, tracked := s.rootPIDs[pgid]
+	return tracked
+}
+
 func (s *StateManager) AddRoot(pid int32) {
 	s.Lock()
 	defer s.Unlock()
 	s.rootPIDs[pid] = struct{}{}
 }
 
-func (s *StateManager) RefreshTree() {
+func (s *StateManager) RefreshTree() []proces.JobEvent {
 	allProcs, err := process.Processes()
 	if err != nil {
-		return
+		return nil
 	}
 
 	s.Lock()
@@ -75,7 +189,7 @@ func (s *StateManager) RefreshTree() {
 
 	if len(s.rootPIDs) == 0 {
 		s.fullTree = make(map[int32]int32)
-		return
+		return nil
 	}
 
 	leaderAlive := make(map[int32]bool)
@@ -100,7 +214,12 @@ func (s *StateManager) RefreshTree() {
 		}
 	}
 
+	events := []proces.JobEvent{}
+	now := time.Now()
 	for pgid := range s.rootPIDs {
+		if _, awaited := s.awaited[pgid]; awaited {
+			continue
+		}
 		if !leaderAlive[pgid] {
 			hasOrphans := false
 			for _, root := range newFullTree {
@@ -112,14 +231,27 @@ func (s *StateManager) RefreshTree() {
 
 			if hasOrphans {
 				log.Printf("Warning: Job PGID %d is orphaned (Leader dead, children active)\n", pgid)
+				if _, reported := s.orphaned[pgid]; !reported {
+					s.orphaned[pgid] = struct{}{}
+					events = append(events, proces.JobEvent{PGID: pgid, State: proces.StateOrphaned, Time: now})
+				}
 
 			} else {
 				delete(s.rootPIDs, pgid)
+				delete(s.orphaned, pgid)
+				delete(s.reaped, pgid)
+				event := proces.JobEvent{PGID: pgid, State: proces.StateFinished, Time: now}
+				if code, ok := s.exits[pgid]; ok {
+					event.ExitCode = &code
+					delete(s.exits, pgid)
+				}
+				events = append(events, event)
 			}
 		}
 	}
 
 	s.fullTree = newFullTree
+	return events
 }
 
 func (s *StateManager) GetSnapshot() map[int32]int32 {
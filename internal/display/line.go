@@ -5,11 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"net"
 	"os"
+	"github.com/Wesenheit/Skaldenmet/internal/client"
 	"github.com/Wesenheit/Skaldenmet/internal/metrics"
 	"github.com/Wesenheit/Skaldenmet/internal/proces"
-	"sort"
+	"github.com/Wesenheit/Skaldenmet/internal/protocol"
+	"github.com/Wesenheit/Skaldenmet/internal/remote"
+	"strings"
 	"syscall"
 	"time"
 
@@ -34,132 +36,151 @@ func IsProcessActive(pgid int32) bool {
 
 	return false
 }
-func RenderTableCPU(data map[int32]metrics.CPUSummaryMetric) {
+// RenderTable renders summaries of a kind as its view says, with holds the
+// summaries of the kinds the view needs besides its own
+func RenderTable(view metrics.View, data map[NodeJob]metrics.Summary, with map[string]map[NodeJob]metrics.Summary, args []string, active Status) {
 	table := tablewriter.NewWriter(os.Stdout)
 
-	table.Header([]string{"PPID", "Process Name", "CPU % (AVG)", "MEM % (AVG)", "Status", "Duration"})
-
-	keys := make([]int, 0, len(data))
-	for k := range data {
-		keys = append(keys, int(k))
+	keys := sortedJobs(data)
+	nodes := hasNodes(keys)
+	header := append([]string{"PPID", "Process Name"}, view.Columns...)
+	if view.Status {
+		header = append(header, "Status")
+	}
+	if view.Duration {
+		header = append(header, "Duration")
 	}
-	sort.Ints(keys)
+	table.Header(withNode(nodes, "Node", header...))
 
-	for _, pid := range keys {
-		metric := data[int32(pid)]
-		var status string
+	for _, key := range keys {
+		summary := data[key]
+		start, end := summary.Span()
+		status := "Finished"
 		var duration time.Duration
-		if IsProcessActive(int32(pid)) {
+		if active(key) {
 			status = "Active"
-			duration = time.Now().Sub(metric.Start)
-		} else {
-			status = "Finished"
-			if !metric.End.IsZero() {
-				duration = metric.End.Sub(metric.Start)
-			}
+			duration = time.Now().Sub(start)
+		} else if !end.IsZero() {
+			duration = end.Sub(start)
+		}
 
+		joined := make(map[string]metrics.Summary, len(with))
+		for kind, summaries := range with {
+			joined[kind] = summaries[key]
 		}
-		row := []string{
-			fmt.Sprintf("%d", pid),
-			metric.Name,
-			fmt.Sprintf("%.2f%%", metric.CPU),
-			fmt.Sprintf("%.2f%%", metric.Memory),
-			status,
-			duration.Truncate(time.Second).String(),
+		for _, columns := range view.Rows(summary, joined, args) {
+			row := append([]string{fmt.Sprintf("%d", key.PGID), summary.JobName()}, columns...)
+			if view.Status {
+				row = append(row, status)
+			}
+			if view.Duration {
+				row = append(row, duration.Truncate(time.Second).String())
+			}
+			table.Append(withNode(nodes, key.Node, row...))
 		}
-		table.Append(row)
 	}
 
 	table.Render()
 }
-func RenderTableGPU(data map[int32]metrics.GPUSummaryMetric) {
-	table := tablewriter.NewWriter(os.Stdout)
-
-	table.Header([]string{"PPID", "Process Name", "GPU Util (AVG)", "MEM (AVG)", "Total power", "Max Temp", "Status", "Duration"})
 
-	keys := make([]int, 0, len(data))
-	for k := range data {
-		keys = append(keys, int(k))
+// fetchSummaries reads summaries of a registered kind, like fetch
+func fetchSummaries(c *client.Client, kind *metrics.Kind) (map[NodeJob]metrics.Summary, error) {
+	raw, err := fetch[json.RawMessage](c, kind.Name)
+	if err != nil {
+		return nil, err
 	}
-	sort.Ints(keys)
-
-	for _, pid := range keys {
-		metric := data[int32(pid)]
-		var status string
-		var duration time.Duration
-		if IsProcessActive(int32(pid)) {
-			status = "Active"
-			duration = time.Now().Sub(metric.Start)
-		} else {
-			status = "Finished"
-			if !metric.End.IsZero() {
-				duration = metric.End.Sub(metric.Start)
-			}
-
-		}
-		row := []string{
-			fmt.Sprintf("%d", pid),
-			metric.Name,
-			fmt.Sprintf("%.2f%%", metric.AvgUtil),
-			fmt.Sprintf("%.2f GB", metric.AvgMemory),
-			fmt.Sprintf("%.2f Wh", metric.Energy),
-			fmt.Sprintf("%.2f C", metric.MaxTemp),
-			status,
-			duration.Truncate(time.Second).String(),
+	summaries := make(map[NodeJob]metrics.Summary, len(raw))
+	for key, data := range raw {
+		summary, err := kind.DecodeSummary(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s summary of job %d: %w", kind.Name, key.PGID, err)
 		}
-		table.Append(row)
+		summaries[key] = summary
 	}
+	return summaries, nil
+}
 
-	table.Render()
+// fetch reads summaries of one kind from the daemon, or from every node of
+// its cluster with --cluster
+func fetch[T any](c *client.Client, kind string) (map[NodeJob]T, error) {
+	if clusterList {
+		return Gather[T](c, kind)
+	}
+	var data map[int32]T
+	if err := c.Do(protocol.Request{Type: kind}, &data); err != nil {
+		return nil, err
+	}
+	return Single(data), nil
+}
+
+// listStatus probes local jobs, jobs of remote daemons are reported running
+// by them
+func listStatus(c *client.Client) (Status, error) {
+	if !clusterList && remote.Target() == "" {
+		return LocalStatus, nil
+	}
+	jobs, err := fetch[proces.Process](c, protocol.TypeJobs)
+	if err != nil {
+		return nil, err
+	}
+	return ReportedStatus(jobs), nil
 }
 
 var ListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "list the files",
-	Args:  cobra.ExactArgs(1),
+	Args:  cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
-		socketPath := "/tmp/skald_serve.socket"
-		conn, err := net.Dial("unix", socketPath)
+		c, err := client.Connect()
 		if err != nil {
-			log.Fatalf("could not connect to daemon: %v", err)
-			return
+			log.Fatal(err)
 		}
-		defer conn.Close()
-		var request proces.Request
-
-		if args[0] == "cpu" {
-			request = proces.Request{Type: "cpu"}
-			err = json.NewEncoder(conn).Encode(request)
-			if err != nil {
-				log.Printf("Failed to encode: %v", err)
-				return
-			}
+		defer c.Close()
 
-			var data map[int32]metrics.CPUSummaryMetric
-			err = json.NewDecoder(conn).Decode(&data)
-			if err != nil {
-				log.Fatalf("failed to decode response: %v", err)
-				return
-			}
-			RenderTableCPU(data)
-		} else if args[0] == "gpu" {
-			request = proces.Request{Type: "gpu"}
-			err = json.NewEncoder(conn).Encode(request)
-			if err != nil {
-				log.Printf("Failed to encode: %v", err)
-				return
-			}
+		active, err := listStatus(c)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-			var data map[int32]metrics.GPUSummaryMetric
-			err = json.NewDecoder(conn).Decode(&data)
-			if err != nil {
-				log.Fatalf("failed to decode response: %v", err)
-				return
-			}
-			RenderTableGPU(data)
-		} else {
-			log.Fatal("Unknown type of data!")
+		kind, ok := metrics.LookupKind(args[0])
+		if !ok {
+			log.Fatalf("Unknown type of data %q, known types are %s", args[0], strings.Join(metrics.KindNames(), ", "))
+		}
+		name := ""
+		if extended {
+			name = "extended"
+		} else if stats {
+			name = "stats"
+		}
+		view, ok := kind.Views[name]
+		if !ok {
+			log.Fatalf("No %s view of %s", name, kind.Name)
 		}
 
+		data, err := fetchSummaries(c, kind)
+		if err != nil {
+			log.Fatal(err)
+		}
+		with := make(map[string]map[NodeJob]metrics.Summary)
+		for _, name := range view.With {
+			other, ok := metrics.LookupKind(name)
+			if !ok {
+				continue
+			}
+			if with[name], err = fetchSummaries(c, other); err != nil {
+				log.Fatal(err)
+			}
+		}
+		RenderTable(view, data, with, args[1:], active)
 	},
 }
+
+var extended bool
+var stats bool
+var clusterList bool
+
+func init() {
+	ListCmd.Flags().BoolVarP(&extended, "extended", "e", false, "show extended GPU metrics (clocks, throttling, PCIe, ECC, fan)")
+	ListCmd.Flags().BoolVarP(&stats, "stats", "s", false, "show distribution of CPU or GPU metrics (min, mean, std, percentiles, max)")
+	ListCmd.Flags().BoolVarP(&clusterList, "cluster", "C", false, "list jobs of every node in the cluster of the daemon")
+}
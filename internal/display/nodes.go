@@ -0,0 +1,115 @@
+package display
+
+import (
+	"log"
+	"sort"
+
+	"github.com/Wesenheit/Skaldenmet/internal/client"
+	"github.com/Wesenheit/Skaldenmet/internal/proces"
+	"github.com/Wesenheit/Skaldenmet/internal/protocol"
+	"github.com/Wesenheit/Skaldenmet/internal/remote"
+)
+
+// NodeJob identifies a job in a listing, Node is only set when the jobs of a
+// whole cluster are listed.
+type NodeJob struct {
+	Node string
+	PGID int32
+}
+
+// Status tells whether a listed job is still running
+type Status func(NodeJob) bool
+
+// Single keys data of a single daemon
+func Single[T any](data map[int32]T) map[NodeJob]T {
+	keyed := make(map[NodeJob]T, len(data))
+	for pgid, value := range data {
+		keyed[NodeJob{PGID: pgid}] = value
+	}
+	return keyed
+}
+
+// unreachable holds nodes already reported as failed, a listing querying
+// several kinds warns about each node only once
+var unreachable = map[string]bool{}
+
+// Gather queries all nodes of the cluster and merges their answers, nodes
+// that could not be queried are reported and skipped.
+func Gather[T any](c *client.Client, kind string) (map[NodeJob]T, error) {
+	nodes, err := client.Gather[map[int32]T](c, protocol.Request{Type: kind})
+	if err != nil {
+		return nil, err
+	}
+	keyed := make(map[NodeJob]T)
+	for _, node := range nodes {
+		if node.Error != "" {
+			if !unreachable[node.Name] {
+				log.Printf("Node %s: %s", node.Name, node.Error)
+				unreachable[node.Name] = true
+			}
+			continue
+		}
+		for pgid, value := range node.Data {
+			keyed[NodeJob{Node: node.Name, PGID: pgid}] = value
+		}
+	}
+	return keyed, nil
+}
+
+func sortedJobs[T any](data map[NodeJob]T) []NodeJob {
+	keys := make([]NodeJob, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Node != keys[j].Node {
+			return keys[i].Node < keys[j].Node
+		}
+		return keys[i].PGID < keys[j].PGID
+	})
+	return keys
+}
+
+func hasNodes(keys []NodeJob) bool {
+	for _, key := range keys {
+		if key.Node != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// withNode prepends the node column when the listing spans several nodes
+func withNode(nodes bool, node string, cells ...string) []string {
+	if !nodes {
+		return cells
+	}
+	return append([]string{node}, cells...)
+}
+
+func isRunning(job proces.Process) bool {
+	return job.State == proces.StateRunning || job.State == proces.StateOrphaned
+}
+
+// LocalStatus probes the process groups of jobs on this machine
+func LocalStatus(key NodeJob) bool {
+	return IsProcessActive(key.PGID)
+}
+
+// ReportedStatus trusts the state of jobs reported by their daemons, process
+// groups of other machines cannot be probed.
+func ReportedStatus(jobs map[NodeJob]proces.Process) Status {
+	return func(key NodeJob) bool {
+		job, ok := jobs[key]
+		return ok && isRunning(job)
+	}
+}
+
+// jobActive tells whether a single job is running, probing it when the
+// daemon runs on this machine.
+func jobActive(job proces.Process) bool {
+	if remote.Target() == "" {
+		return IsProcessActive(job.PGID)
+	}
+	return isRunning(job)
+}
@@ -0,0 +1,235 @@
+package display
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Wesenheit/Skaldenmet/internal/client"
+	"github.com/Wesenheit/Skaldenmet/internal/metrics"
+	"github.com/Wesenheit/Skaldenmet/internal/proces"
+
+	"github.com/shirou/gopsutil/v4/mem"
+	"github.com/spf13/cobra"
+)
+
+// FindJob resolves a job given either its PGID or its name, the most
+// recently started job wins when several share a name.
+func FindJob(jobs map[int32]proces.Process, job string) (proces.Process, error) {
+	if pgid, err := strconv.Atoi(job); err == nil {
+		if proc, ok := jobs[int32(pgid)]; ok {
+			return proc, nil
+		}
+	}
+	var found *proces.Process
+	for _, proc := range jobs {
+		if proc.Name == job && (found == nil || proc.StartTime.After(found.StartTime)) {
+			found = &proc
+		}
+	}
+	if found == nil {
+		return proces.Process{}, fmt.Errorf("job %s not found", job)
+	}
+	return *found, nil
+}
+
+type SeffReport struct {
+	Job      proces.Process
+	Active   bool
+	Wall     time.Duration
+	Cores    int
+	CPUTime  time.Duration
+	CPUEff   float64
+	PeakMem  uint64
+	MemLimit uint64
+	MemEff   float64
+	Requests bool
+	CPU      metrics.CPUSummaryMetric
+	GPU      metrics.GPUSummaryMetric
+	Energy   metrics.EnergySummaryMetric
+	Hints    []string
+}
+
+func NewSeffReport(job proces.Process, cpu metrics.CPUSummaryMetric, gpu metrics.GPUSummaryMetric, energy metrics.EnergySummaryMetric) SeffReport {
+	report := SeffReport{
+		Job:    job,
+		Active: jobActive(job),
+		Cores:  job.Cpus,
+		CPU:    cpu,
+		GPU:    gpu,
+		Energy: energy,
+	}
+
+	end := cpu.End
+	for _, candidate := range []time.Time{gpu.End, energy.End} {
+		if candidate.After(end) {
+			end = candidate
+		}
+	}
+	if report.Active {
+		end = time.Now()
+	}
+	if end.After(job.StartTime) {
+		report.Wall = end.Sub(job.StartTime)
+	}
+
+	if report.Cores <= 0 {
+		report.Cores = runtime.NumCPU()
+	}
+	report.CPUTime = time.Duration(cpu.CPU / 100 * float64(report.Wall))
+	if report.Wall > 0 {
+		report.CPUEff = 100 * float64(report.CPUTime) / (float64(report.Cores) * float64(report.Wall))
+	}
+
+	report.PeakMem = cpu.PeakRSS
+	if cpu.PeakPSS > 0 {
+		report.PeakMem = cpu.PeakPSS
+	}
+	report.MemLimit = job.Memory
+	if report.MemLimit == 0 {
+		if vm, err := mem.VirtualMemory(); err == nil {
+			report.MemLimit = vm.Total
+		}
+	}
+	if report.MemLimit > 0 {
+		report.MemEff = 100 * float64(report.PeakMem) / float64(report.MemLimit)
+	}
+	report.Requests = job.Cpus > 0 || job.Memory > 0
+	report.Hints = report.hints()
+	return report
+}
+
+func (r SeffReport) devices() []int {
+	devices := make([]int, 0, len(r.GPU.Devices))
+	for device := range r.GPU.Devices {
+		devices = append(devices, device)
+	}
+	sort.Ints(devices)
+	return devices
+}
+
+func (r SeffReport) hints() []string {
+	hints := []string{}
+	if r.Wall < time.Minute {
+		hints = append(hints, "Job ran for less than a minute, efficiency figures may be inaccurate")
+	}
+	if r.Wall > 0 && r.CPUEff < 50 {
+		used := float64(r.CPUTime) / float64(r.Wall)
+		hints = append(hints, fmt.Sprintf("CPU efficiency is low: on average %.1f of %d cores were busy, consider requesting fewer cores", used, r.Cores))
+	}
+	if r.Job.Memory > 0 {
+		if r.PeakMem > r.Job.Memory {
+			hints = append(hints, "Peak memory exceeded the requested amount")
+		} else if r.MemEff < 25 {
+			hints = append(hints, fmt.Sprintf("Only %.0f%% of requested memory was used, consider a smaller --mem", r.MemEff))
+		}
+	}
+	if r.CPU.PeakSwap > 0 {
+		hints = append(hints, fmt.Sprintf("Job swapped up to %s, it may be memory bound", metrics.FormatBytes(float64(r.CPU.PeakSwap))))
+	}
+	for _, device := range r.devices() {
+		summary := r.GPU.Devices[device]
+		if summary.Duration <= 0 {
+			continue
+		}
+		idle := 100 * summary.IdleTime / summary.Duration
+		if idle >= 20 {
+			hints = append(hints, fmt.Sprintf("GPU %d idle %.0f%% of runtime", device, idle))
+		}
+		if summary.MemoryTotal > 0 && 100*summary.AvgMemory/summary.MemoryTotal < 10 {
+			hints = append(hints, fmt.Sprintf("GPU %d memory mostly unused, a smaller GPU or MIG instance may suffice", device))
+		}
+	}
+	if r.Wall > 0 && r.GPU.ThrottledTime/r.Wall.Seconds() > 0.1 {
+		hints = append(hints, fmt.Sprintf("GPU clocks were throttled %.0f%% of runtime (%s)",
+			100*r.GPU.ThrottledTime/r.Wall.Seconds(), strings.Join(metrics.ThrottleReasonNames(r.GPU.ThrottleReasons&metrics.ThrottlingMask), ", ")))
+	}
+	return hints
+}
+
+func (r SeffReport) Print() {
+	state := "Finished"
+	if r.Active {
+		state = "Active"
+	}
+	coresNote := ""
+	if r.Job.Cpus <= 0 {
+		coresNote = " (not requested, all cores assumed)"
+	}
+	memNote := "requested"
+	if r.Job.Memory == 0 {
+		memNote = "system memory, not requested"
+	}
+
+	fmt.Printf("Job name: %s\n", r.Job.Name)
+	fmt.Printf("PGID: %d\n", r.Job.PGID)
+	fmt.Printf("State: %s\n", state)
+	fmt.Printf("Cores: %d%s\n", r.Cores, coresNote)
+	fmt.Printf("Wall-clock time: %s\n", r.Wall.Truncate(time.Second))
+	fmt.Printf("CPU utilized: %s\n", r.CPUTime.Truncate(time.Second))
+	fmt.Printf("CPU efficiency: %.2f%% of %s core-walltime\n", r.CPUEff, (time.Duration(r.Cores) * r.Wall).Truncate(time.Second))
+	fmt.Printf("Memory utilized: %s (peak)\n", metrics.FormatBytes(float64(r.PeakMem)))
+	fmt.Printf("Memory efficiency: %.2f%% of %s (%s)\n", r.MemEff, metrics.FormatBytes(float64(r.MemLimit)), memNote)
+	for _, device := range r.devices() {
+		summary := r.GPU.Devices[device]
+		var memEff, idle float64
+		if summary.MemoryTotal > 0 {
+			memEff = 100 * summary.AvgMemory / summary.MemoryTotal
+		}
+		if summary.Duration > 0 {
+			idle = 100 * summary.IdleTime / summary.Duration
+		}
+		fmt.Printf("GPU %d: utilisation %.2f%%, memory %.2f GB of %.2f GB (%.2f%%), idle %.2f%% of runtime\n",
+			device, summary.AvgUtil, summary.AvgMemory, summary.MemoryTotal, memEff, idle)
+	}
+	fmt.Printf("Energy: CPU %.2f Wh, GPU %.2f Wh, total %.2f Wh\n", r.Energy.TotalWh(), r.GPU.Energy, r.Energy.TotalWh()+r.GPU.Energy)
+
+	if len(r.Hints) > 0 {
+		fmt.Println()
+		fmt.Println("Hints:")
+		for _, hint := range r.Hints {
+			fmt.Printf(" - %s\n", hint)
+		}
+	}
+}
+
+var SeffCmd = &cobra.Command{
+	Use:   "seff <job>",
+	Short: "show efficiency report of a job",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := client.Connect()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer c.Close()
+
+		jobs, err := c.Jobs()
+		if err != nil {
+			log.Fatal(err)
+		}
+		job, err := FindJob(jobs, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		cpuData, err := client.SummaryOf[metrics.CPUSummaryMetric](c, metrics.KindCPU, job.PGID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		gpuData, err := client.SummaryOf[metrics.GPUSummaryMetric](c, metrics.KindGPU, job.PGID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		energyData, err := client.SummaryOf[metrics.EnergySummaryMetric](c, metrics.KindEnergy, job.PGID)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		NewSeffReport(job, cpuData, gpuData, energyData).Print()
+	},
+}
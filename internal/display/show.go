@@ -0,0 +1,320 @@
+package display
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Wesenheit/Skaldenmet/internal/client"
+	"github.com/Wesenheit/Skaldenmet/internal/metrics"
+	"github.com/Wesenheit/Skaldenmet/internal/proces"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// ProcessTree orders processes depth first from the roots of the job, the
+// returned depth is used for indentation.
+func ProcessTree(processes map[int32]metrics.ProcessSummary) ([]metrics.ProcessSummary, []int) {
+	children := make(map[int32][]int32)
+	roots := []int32{}
+	for pid, proc := range processes {
+		if _, hasParent := processes[proc.Parent]; hasParent && proc.Parent != pid {
+			children[proc.Parent] = append(children[proc.Parent], pid)
+		} else {
+			roots = append(roots, pid)
+		}
+	}
+	byStart := func(pids []int32) {
+		sort.Slice(pids, func(i, j int) bool {
+			a, b := processes[pids[i]], processes[pids[j]]
+			if !a.Start.Equal(b.Start) {
+				return a.Start.Before(b.Start)
+			}
+			return a.Pid < b.Pid
+		})
+	}
+	byStart(roots)
+
+	ordered := []metrics.ProcessSummary{}
+	depths := []int{}
+	var walk func(pid int32, depth int)
+	walk = func(pid int32, depth int) {
+		ordered = append(ordered, processes[pid])
+		depths = append(depths, depth)
+		byStart(children[pid])
+		for _, child := range children[pid] {
+			walk(child, depth+1)
+		}
+	}
+	for _, root := range roots {
+		walk(root, 0)
+	}
+	return ordered, depths
+}
+
+func oneLine(command string) string {
+	return strings.Join(strings.Fields(command), " ")
+}
+
+func treePrefix(depth int) string {
+	if depth == 0 {
+		return ""
+	}
+	return strings.Repeat("│ ", depth-1) + "└─ "
+}
+
+func RenderTableProcesses(processes map[int32]metrics.ProcessSummary) {
+	table := tablewriter.NewWriter(os.Stdout)
+
+	table.Header([]string{"PID", "Parent", "Command", "CPU % (AVG)", "MEM % (AVG)", "RSS (PEAK)", "GPU Util (AVG)", "GPU MEM (AVG)", "Start", "End"})
+
+	ordered, depths := ProcessTree(processes)
+	for i, proc := range ordered {
+		command := oneLine(proc.Cmdline)
+		if len(command) > 60 {
+			command = command[:57] + "..."
+		}
+		row := []string{
+			fmt.Sprintf("%d", proc.Pid),
+			fmt.Sprintf("%d", proc.Parent),
+			treePrefix(depths[i]) + command,
+			fmt.Sprintf("%.2f%%", proc.CPU),
+			fmt.Sprintf("%.2f%%", proc.Memory),
+			metrics.FormatBytes(float64(proc.PeakRSS)),
+			fmt.Sprintf("%.2f%%", proc.GPUUtil),
+			fmt.Sprintf("%.2f GB", proc.GPUMemory),
+			proc.Start.Format(time.TimeOnly),
+			proc.End.Format(time.TimeOnly),
+		}
+		table.Append(row)
+	}
+
+	table.Render()
+}
+
+// JobDetails gathers everything the daemon knows about a single job
+type JobDetails struct {
+	Job proces.Process
+	// Summaries of the job by kind, kinds without data for the job are left out
+	Summaries map[string]metrics.Summary
+	Processes map[int32]metrics.ProcessSummary
+}
+
+// detailed kinds have their own section in Print, other kinds are printed
+// with their default view
+var detailed = []string{metrics.KindCPU, metrics.KindGPU, metrics.KindIO, metrics.KindNet, metrics.KindEnergy}
+
+func FetchJobDetails(c *client.Client, job proces.Process) (JobDetails, error) {
+	details := JobDetails{Job: job, Summaries: make(map[string]metrics.Summary)}
+	for _, kind := range metrics.Kinds() {
+		summaries, err := c.Summaries(kind)
+		if err != nil {
+			return details, err
+		}
+		if summary, ok := summaries[job.PGID]; ok {
+			details.Summaries[kind.Name] = summary
+		}
+	}
+	var err error
+	details.Processes, err = c.Processes(job.PGID)
+	return details, err
+}
+
+// summary returns the summary of kind, the zero summary when there is none
+func summary[S metrics.Summary](d JobDetails, kind string) S {
+	found, _ := d.Summaries[kind].(S)
+	return found
+}
+
+// MarshalJSON writes the job, the summary of every registered kind under its
+// name and the processes
+func (d JobDetails) MarshalJSON() ([]byte, error) {
+	fields := map[string]any{"job": d.Job, "processes": d.Processes}
+	for _, kind := range metrics.Kinds() {
+		if found, ok := d.Summaries[kind.Name]; ok {
+			fields[kind.Name] = found
+		} else {
+			fields[kind.Name] = kind.NewSummary(d.Job.StartTime, d.Job.Name)
+		}
+	}
+	return json.Marshal(fields)
+}
+
+func optional(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func (d JobDetails) Print() {
+	job := d.Job
+	state := job.State
+	if state == "" {
+		state = "unknown"
+	}
+	exitCode := "-"
+	if job.ExitCode != nil {
+		exitCode = fmt.Sprintf("%d", *job.ExitCode)
+	}
+	end := job.EndTime
+	if end.IsZero() {
+		end = time.Now()
+	}
+	cpus := "-"
+	if job.Cpus > 0 {
+		cpus = fmt.Sprintf("%d", job.Cpus)
+	}
+	memory := "-"
+	if job.Memory > 0 {
+		memory = metrics.FormatBytes(float64(job.Memory))
+	}
+
+	fmt.Printf("Job name:        %s\n", job.Name)
+	fmt.Printf("Job ID:          %d\n", job.ID)
+	fmt.Printf("User:            %s\n", optional(job.User))
+	fmt.Printf("PGID:            %d\n", job.PGID)
+	fmt.Printf("Command:         %s\n", job.Command)
+	fmt.Printf("Working dir:     %s\n", optional(job.WorkDir))
+	fmt.Printf("Stdout:          %s\n", optional(job.LogPath))
+	fmt.Printf("Stderr:          %s\n", optional(job.ErrPath))
+	fmt.Printf("Env digest:      %s\n", optional(job.EnvDigest))
+	fmt.Printf("Requested:       cpus=%s mem=%s\n", cpus, memory)
+	fmt.Printf("State:           %s\n", state)
+	fmt.Printf("Exit code:       %s\n", exitCode)
+	fmt.Printf("Start:           %s\n", job.StartTime.Format(time.DateTime))
+	if !job.EndTime.IsZero() {
+		fmt.Printf("End:             %s\n", job.EndTime.Format(time.DateTime))
+	}
+	fmt.Printf("Elapsed:         %s\n", end.Sub(job.StartTime).Truncate(time.Second))
+
+	cpu := summary[metrics.CPUSummaryMetric](d, metrics.KindCPU)
+	gpu := summary[metrics.GPUSummaryMetric](d, metrics.KindGPU)
+	disk := summary[metrics.IOSummaryMetric](d, metrics.KindIO)
+	network := summary[metrics.NetSummaryMetric](d, metrics.KindNet)
+	energy := summary[metrics.EnergySummaryMetric](d, metrics.KindEnergy)
+
+	fmt.Println()
+	fmt.Println("CPU")
+	fmt.Printf("  CPU:           %.2f%% (AVG), p95 %.2f%%, max %.2f%%\n", cpu.CPU, cpu.CPUStats.Quantile(0.95), cpu.CPUStats.Max)
+	fmt.Printf("  RSS:           %s (AVG), %s (PEAK)\n", metrics.FormatBytes(cpu.AvgRSS), metrics.FormatBytes(float64(cpu.PeakRSS)))
+	fmt.Printf("  Swap:          %s (PEAK)\n", metrics.FormatBytes(float64(cpu.PeakSwap)))
+	fmt.Printf("  Energy:        %.2f Wh\n", energy.TotalWh())
+
+	if len(gpu.Devices) > 0 {
+		fmt.Println()
+		fmt.Println("GPU")
+		fmt.Printf("  Utilisation:   %.2f%% (AVG)\n", gpu.AvgUtil)
+		fmt.Printf("  Memory:        %.2f GB (AVG)\n", gpu.AvgMemory)
+		fmt.Printf("  Max temp:      %.2f C\n", gpu.MaxTemp)
+		fmt.Printf("  Energy:        %.2f Wh\n", gpu.Energy)
+		devices := make([]int, 0, len(gpu.Devices))
+		for device := range gpu.Devices {
+			devices = append(devices, device)
+		}
+		sort.Ints(devices)
+		for _, device := range devices {
+			summary := gpu.Devices[device]
+			fmt.Printf("  GPU %d:         %.2f%% util, %.2f/%.2f GB\n", device, summary.AvgUtil, summary.AvgMemory, summary.MemoryTotal)
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("I/O")
+	fmt.Printf("  Disk:          read %s, written %s\n", metrics.FormatBytes(float64(disk.ReadBytes)), metrics.FormatBytes(float64(disk.WriteBytes)))
+	fmt.Printf("  Network:       sent %s, received %s\n", metrics.FormatBytes(float64(network.BytesSent)), metrics.FormatBytes(float64(network.BytesRecv)))
+
+	for _, kind := range metrics.Kinds() {
+		if !slices.Contains(detailed, kind.Name) {
+			d.printKind(kind)
+		}
+	}
+
+	if len(d.Processes) > 0 {
+		fmt.Println()
+		fmt.Println("Process tree")
+		ordered, depths := ProcessTree(d.Processes)
+		for i, proc := range ordered {
+			fmt.Printf("  %s%d %s\n", treePrefix(depths[i]), proc.Pid, oneLine(proc.Cmdline))
+		}
+	}
+}
+
+// printKind renders the summary of kind with its default view
+func (d JobDetails) printKind(kind *metrics.Kind) {
+	found, ok := d.Summaries[kind.Name]
+	view, hasView := kind.Views[""]
+	if !ok || !hasView {
+		return
+	}
+	with := make(map[string]metrics.Summary)
+	for _, name := range view.With {
+		with[name] = d.Summaries[name]
+	}
+	rows := view.Rows(found, with, nil)
+	if len(rows) == 0 {
+		return
+	}
+	fmt.Println()
+	fmt.Println(strings.ToUpper(kind.Name))
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header(view.Columns)
+	for _, row := range rows {
+		table.Append(row)
+	}
+	table.Render()
+}
+
+var ShowCmd = &cobra.Command{
+	Use:   "show <job>",
+	Short: "show details of a single job",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := client.Connect()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer c.Close()
+
+		jobs, err := c.Jobs()
+		if err != nil {
+			log.Fatal(err)
+		}
+		job, err := FindJob(jobs, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		details, err := FetchJobDetails(c, job)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		switch {
+		case showJSON:
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(details); err != nil {
+				log.Fatal(err)
+			}
+		case showProcesses:
+			RenderTableProcesses(details.Processes)
+		default:
+			details.Print()
+		}
+	},
+}
+
+var showProcesses bool
+var showJSON bool
+
+func init() {
+	ShowCmd.Flags().BoolVarP(&showProcesses, "processes", "p", false, "list every process of the job")
+	ShowCmd.Flags().BoolVar(&showJSON, "json", false, "print the job as JSON")
+}
@@ -0,0 +1,95 @@
+package display
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Wesenheit/Skaldenmet/internal/client"
+	"github.com/Wesenheit/Skaldenmet/internal/events"
+	"github.com/Wesenheit/Skaldenmet/internal/metrics"
+	"github.com/Wesenheit/Skaldenmet/internal/protocol"
+
+	"github.com/spf13/cobra"
+)
+
+func describeSample(sample events.Sample) string {
+	if kind, ok := metrics.LookupSample(sample.Kind); ok {
+		return kind.DescribeSample(sample.Data)
+	}
+	return sample.Kind
+}
+
+func printEvent(line []byte) error {
+	var event events.Event
+	if err := json.Unmarshal(line, &event); err != nil {
+		return err
+	}
+	stamp := event.Time.Format("15:04:05")
+	switch event.Type {
+	case events.TypeSamples:
+		for _, sample := range event.Samples {
+			fmt.Printf("%s job %d %s\n", stamp, sample.Job, describeSample(sample))
+		}
+	case events.TypeJob:
+		fmt.Printf("%s job %d %s\n", stamp, event.Job.PGID, event.Job.State)
+	case events.TypeAlert:
+		fmt.Printf("%s job %d ALERT %s: %s\n", stamp, event.Alert.Job, event.Alert.Kind, event.Alert.Message)
+	case events.TypeDropped:
+		fmt.Printf("%s %d events dropped, client too slow\n", stamp, event.Dropped)
+	}
+	return nil
+}
+
+var WatchCmd = &cobra.Command{
+	Use:   "watch [job]",
+	Short: "stream samples, job state changes and alerts as they happen",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := client.Connect()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer c.Close()
+
+		request := protocol.Request{}
+		if watchEvents != "" {
+			request.Events = strings.Split(watchEvents, ",")
+		}
+		if len(args) == 1 {
+			jobs, err := c.Jobs()
+			if err != nil {
+				log.Fatal(err)
+			}
+			job, err := FindJob(jobs, args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			request.Job = job.PGID
+		}
+
+		err = c.Subscribe(request, func(event json.RawMessage) error {
+			if watchJSON {
+				os.Stdout.Write(append(event, '\n'))
+				return nil
+			}
+			if err := printEvent(event); err != nil {
+				log.Printf("failed to decode event: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var watchEvents string
+var watchJSON bool
+
+func init() {
+	WatchCmd.Flags().StringVarP(&watchEvents, "events", "e", "", "comma separated event types: samples, job, alert")
+	WatchCmd.Flags().BoolVar(&watchJSON, "json", false, "print raw newline delimited JSON events")
+}
@@ -0,0 +1,203 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Wesenheit/Skaldenmet/internal/metrics"
+	"github.com/Wesenheit/Skaldenmet/internal/proces"
+)
+
+const (
+	TypeSamples = "samples"
+	TypeJob     = "job"
+	TypeAlert   = "alert"
+	TypeDropped = "dropped"
+)
+
+type Sample struct {
+	Kind string         `json:"kind"`
+	Job  int32          `json:"job"`
+	Data metrics.Metric `json:"data"`
+}
+
+type Alert struct {
+	Job     int32  `json:"job"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// Event is a single line of the subscription stream
+type Event struct {
+	Type    string           `json:"type"`
+	Time    time.Time        `json:"time"`
+	Samples []Sample         `json:"samples,omitempty"`
+	Job     *proces.JobEvent `json:"job,omitempty"`
+	Alert   *Alert           `json:"alert,omitempty"`
+	Dropped uint64           `json:"dropped,omitempty"`
+}
+
+// MetricKind tags a sample with the sample name of its kind
+func MetricKind(metric metrics.Metric) string {
+	if kind, ok := metrics.KindOf(metric); ok {
+		return kind.SampleName()
+	}
+	return "unknown"
+}
+
+// UnmarshalJSON restores the concrete metric type from the sample kind
+func (s *Sample) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Kind string          `json:"kind"`
+		Job  int32           `json:"job"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	kind, ok := metrics.LookupSample(raw.Kind)
+	if !ok {
+		return fmt.Errorf("unknown sample kind %s", raw.Kind)
+	}
+	metric := kind.NewSample()
+	if err := json.Unmarshal(raw.Data, metric); err != nil {
+		return err
+	}
+	*s = Sample{Kind: raw.Kind, Job: raw.Job, Data: metric}
+	return nil
+}
+
+// NewSamples tags every metric with its kind and job
+func NewSamples(batch []metrics.Metric) []Sample {
+	samples := make([]Sample, 0, len(batch))
+	for _, metric := range batch {
+		samples = append(samples, Sample{Kind: MetricKind(metric), Job: metric.PPid(), Data: metric})
+	}
+	return samples
+}
+
+func SamplesEvent(batch []metrics.Metric) Event {
+	return Event{Type: TypeSamples, Time: time.Now(), Samples: NewSamples(batch)}
+}
+
+// Filter restricts a subscription to some event types and a single job,
+// zero values match everything. Allow, when set, hides jobs the subscriber
+// may not see.
+type Filter struct {
+	Types []string
+	Job   int32
+	Allow func(job int32) bool
+}
+
+func (f Filter) matches(job int32) bool {
+	return (f.Job == 0 || job == f.Job) && (f.Allow == nil || f.Allow(job))
+}
+
+// Apply returns the part of the event accepted by the filter
+func (f Filter) Apply(event Event) (Event, bool) {
+	if len(f.Types) > 0 {
+		accepted := false
+		for _, t := range f.Types {
+			accepted = accepted || t == event.Type
+		}
+		if !accepted {
+			return event, false
+		}
+	}
+	if f.Job == 0 && f.Allow == nil {
+		return event, true
+	}
+	switch event.Type {
+	case TypeSamples:
+		samples := []Sample{}
+		for _, sample := range event.Samples {
+			if f.matches(sample.Job) {
+				samples = append(samples, sample)
+			}
+		}
+		event.Samples = samples
+		return event, len(samples) > 0
+	case TypeJob:
+		return event, f.matches(event.Job.PGID)
+	case TypeAlert:
+		return event, f.matches(event.Alert.Job)
+	}
+	return event, true
+}
+
+type Subscription struct {
+	C       chan Event
+	filter  Filter
+	dropped atomic.Uint64
+}
+
+// Dropped returns and resets the number of events lost since the last call
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Swap(0)
+}
+
+// Broker fans events out to subscribers. Publishing never blocks, a
+// subscriber that does not keep up loses events and is told how many.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+	buffer      int
+	closed      bool
+}
+
+func NewBroker(buffer int) *Broker {
+	return &Broker{
+		subscribers: make(map[*Subscription]struct{}),
+		buffer:      buffer,
+	}
+}
+
+func (b *Broker) Subscribe(filter Filter) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub := &Subscription{C: make(chan Event, b.buffer), filter: filter}
+	if b.closed {
+		close(sub.C)
+		return sub
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.C)
+	}
+}
+
+func (b *Broker) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subscribers {
+		filtered, ok := sub.filter.Apply(event)
+		if !ok {
+			continue
+		}
+		select {
+		case sub.C <- filtered:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub.C)
+	}
+}
@@ -0,0 +1,201 @@
+package events
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Wesenheit/Skaldenmet/internal/metrics"
+	"github.com/Wesenheit/Skaldenmet/internal/proces"
+)
+
+func jobEvent(pgid int32) Event {
+	return Event{Type: TypeJob, Job: &proces.JobEvent{PGID: pgid, State: proces.StateFinished}}
+}
+
+func alertEvent(job int32) Event {
+	return Event{Type: TypeAlert, Alert: &Alert{Job: job, Kind: "memory", Message: "close to the limit"}}
+}
+
+func samplesEvent(jobs ...int32) Event {
+	batch := []metrics.Metric{}
+	for _, job := range jobs {
+		batch = append(batch, &metrics.CPUMetric{Pid_id: job + 1, PPID: job, CPU: 50})
+	}
+	return SamplesEvent(batch)
+}
+
+func drain(sub *Subscription) []Event {
+	received := []Event{}
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return received
+			}
+			received = append(received, event)
+		default:
+			return received
+		}
+	}
+}
+
+func TestBrokerDropsForSlowSubscribers(t *testing.T) {
+	cases := []struct {
+		name      string
+		buffer    int
+		published int
+		received  int
+	}{
+		{"fits the buffer", 4, 3, 3},
+		{"fills the buffer", 4, 4, 4},
+		{"overflows the buffer", 2, 5, 2},
+		{"no buffer", 0, 3, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			broker := NewBroker(c.buffer)
+			slow := broker.Subscribe(Filter{})
+			done := make(chan struct{})
+			go func() {
+				for i := range c.published {
+					broker.Publish(jobEvent(int32(i + 1)))
+				}
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Publish blocked on a subscriber that does not read")
+			}
+
+			received := drain(slow)
+			if len(received) != c.received {
+				t.Errorf("received %d events, want %d", len(received), c.received)
+			}
+			for i, event := range received {
+				if event.Job.PGID != int32(i+1) {
+					t.Errorf("event %d is of job %d, the oldest events are kept", i, event.Job.PGID)
+				}
+			}
+			if dropped := slow.Dropped(); dropped != uint64(c.published-c.received) {
+				t.Errorf("dropped %d, want %d", dropped, c.published-c.received)
+			}
+			if dropped := slow.Dropped(); dropped != 0 {
+				t.Errorf("dropped %d after reading the count, want it reset", dropped)
+			}
+		})
+	}
+}
+
+func TestBrokerSubscribersAreIndependent(t *testing.T) {
+	broker := NewBroker(1)
+	slow := broker.Subscribe(Filter{})
+	fast := broker.Subscribe(Filter{})
+	for i := range 3 {
+		broker.Publish(jobEvent(int32(i + 1)))
+		if received := drain(fast); len(received) != 1 {
+			t.Fatalf("fast subscriber received %d events, want 1", len(received))
+		}
+	}
+	if dropped := fast.Dropped(); dropped != 0 {
+		t.Errorf("fast subscriber dropped %d events", dropped)
+	}
+	if dropped := slow.Dropped(); dropped != 2 {
+		t.Errorf("slow subscriber dropped %d events, want 2", dropped)
+	}
+}
+
+func TestBrokerClose(t *testing.T) {
+	broker := NewBroker(4)
+	left := broker.Subscribe(Filter{})
+	stayed := broker.Subscribe(Filter{})
+	broker.Unsubscribe(left)
+	broker.Unsubscribe(left)
+	if _, ok := <-left.C; ok {
+		t.Error("channel of an unsubscribed subscriber is open")
+	}
+	broker.Publish(jobEvent(1))
+	broker.Close()
+	if received := drain(stayed); len(received) != 1 {
+		t.Errorf("received %d events before close, want 1", len(received))
+	}
+	if _, ok := <-stayed.C; ok {
+		t.Error("channel is open after the broker closed")
+	}
+	late := broker.Subscribe(Filter{})
+	if _, ok := <-late.C; ok {
+		t.Error("subscribing to a closed broker gave an open channel")
+	}
+	broker.Publish(jobEvent(2))
+	broker.Unsubscribe(stayed)
+}
+
+func TestFilter(t *testing.T) {
+	cases := []struct {
+		name    string
+		filter  Filter
+		event   Event
+		passes  bool
+		samples int
+	}{
+		{"everything", Filter{}, samplesEvent(1, 2), true, 2},
+		{"type accepted", Filter{Types: []string{TypeAlert, TypeJob}}, jobEvent(1), true, 0},
+		{"type refused", Filter{Types: []string{TypeAlert}}, jobEvent(1), false, 0},
+		{"job of samples", Filter{Job: 2}, samplesEvent(1, 2, 2), true, 2},
+		{"no samples of the job", Filter{Job: 3}, samplesEvent(1, 2), false, 0},
+		{"job event of another job", Filter{Job: 3}, jobEvent(1), false, 0},
+		{"alert of the job", Filter{Job: 3}, alertEvent(3), true, 0},
+		{"allowed jobs", Filter{Allow: func(job int32) bool { return job != 1 }}, samplesEvent(1, 2), true, 1},
+		{"hidden job", Filter{Allow: func(job int32) bool { return job != 1 }}, jobEvent(1), false, 0},
+		{"hidden alert", Filter{Allow: func(job int32) bool { return false }}, alertEvent(1), false, 0},
+		{"dropped notice", Filter{Job: 3}, Event{Type: TypeDropped, Dropped: 4}, true, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			before := len(c.event.Samples)
+			filtered, passes := c.filter.Apply(c.event)
+			if passes != c.passes {
+				t.Fatalf("passes %v, want %v", passes, c.passes)
+			}
+			if passes && len(filtered.Samples) != c.samples {
+				t.Errorf("%d samples passed, want %d", len(filtered.Samples), c.samples)
+			}
+			if len(c.event.Samples) != before {
+				t.Error("filtering changed the published event")
+			}
+		})
+	}
+}
+
+func TestSampleRoundTrip(t *testing.T) {
+	batch := []metrics.Metric{
+		&metrics.CPUMetric{Pid_id: 2, PPID: 1, CPU: 12.5, RSS: 1 << 20},
+		&metrics.GPUMetric{Pid_id: 2, PPid_id: 1, Device: 1, Util: 80},
+		&metrics.Sample{Pid_id: 3, PPID: 1, Name: "threads", Unit: "", Value: 7, Labels: map[string]string{"device": "0"}},
+	}
+	data, err := json.Marshal(SamplesEvent(batch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Samples) != len(batch) {
+		t.Fatalf("decoded %d samples, want %d", len(event.Samples), len(batch))
+	}
+	kinds := []string{metrics.KindCPU, metrics.KindGPU, "metric"}
+	for i, sample := range event.Samples {
+		if sample.Kind != kinds[i] || sample.Job != 1 {
+			t.Errorf("sample %d tagged %s of job %d, want %s of job 1", i, sample.Kind, sample.Job, kinds[i])
+		}
+		if !reflect.DeepEqual(sample.Data, batch[i]) {
+			t.Errorf("sample %d decoded as %+v, want %+v", i, sample.Data, batch[i])
+		}
+	}
+	if err := json.Unmarshal([]byte(`{"kind":"bogus","job":1,"data":{}}`), &Sample{}); err == nil {
+		t.Error("a sample of an unknown kind was decoded")
+	}
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"time"
+)
+
+// EnergyMetric holds CPU energy attributed to a process during one sample
+type EnergyMetric struct {
+	Pid_id   int32
+	PPID     int32
+	PackageJ float64
+	DramJ    float64
+	Time     time.Time
+}
+
+func (m *EnergyMetric) Pid() int32 {
+	return m.Pid_id
+}
+
+func (m *EnergyMetric) PPid() int32 {
+	return m.PPID
+}
+
+func (m *EnergyMetric) Timestamp() time.Time {
+	return m.Time
+}
+
+type EnergySummaryMetric struct {
+	Start     time.Time
+	End       time.Time
+	PackageWh float64
+	DramWh    float64
+	Name      string
+}
+
+func (m EnergySummaryMetric) JobName() string {
+	return m.Name
+}
+
+func (m EnergySummaryMetric) Span() (time.Time, time.Time) {
+	return m.Start, m.End
+}
+
+func AggregateUniqueEnergy(before EnergySummaryMetric, metrics []EnergyMetric) EnergySummaryMetric {
+	if len(metrics) == 0 {
+		return before
+	}
+
+	after := before
+	for _, metric := range metrics {
+		if metric.Time.After(after.End) {
+			after.End = metric.Time
+		}
+		after.PackageWh += metric.PackageJ / 3600 // J to Wh conversion
+		after.DramWh += metric.DramJ / 3600
+	}
+	return after
+}
+
+func (m EnergySummaryMetric) TotalWh() float64 {
+	return m.PackageWh + m.DramWh
+}
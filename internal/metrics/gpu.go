@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"maps"
+	"slices"
 	"time"
 )
 
@@ -9,10 +11,68 @@ type GPUMetric struct {
 	PPid_id     int32
 	Util        float64
 	Memory      float64
+	MemoryTotal float64
 	Device      int
 	PowerW      float64
 	Temperature float64
 	Time        time.Time
+
+	// MIG instance the process runs on, empty UUID for a full GPU
+	MigUUID         string
+	GpuInstance     int
+	ComputeInstance int
+
+	// Extended fields, filled only when enabled in nvidiaCollector.extended
+	SMClock         float64
+	MemClock        float64
+	ThrottleReasons uint64
+	MemBandwidth    float64
+	PcieTx          float64
+	PcieRx          float64
+	EccCorrected    uint64
+	EccUncorrected  uint64
+	FanSpeed        float64
+}
+
+// Clock throttle reason bits as reported by NVML
+const (
+	ThrottleGpuIdle            uint64 = 1
+	ThrottleApplicationsClocks uint64 = 2
+	ThrottleSwPowerCap         uint64 = 4
+	ThrottleHwSlowdown         uint64 = 8
+	ThrottleSyncBoost          uint64 = 16
+	ThrottleSwThermalSlowdown  uint64 = 32
+	ThrottleHwThermalSlowdown  uint64 = 64
+	ThrottleHwPowerBrake       uint64 = 128
+	ThrottleDisplayClocks      uint64 = 256
+)
+
+var throttleNames = []struct {
+	bit  uint64
+	name string
+}{
+	{ThrottleSwPowerCap, "SwPowerCap"},
+	{ThrottleHwSlowdown, "HwSlowdown"},
+	{ThrottleSwThermalSlowdown, "SwThermal"},
+	{ThrottleHwThermalSlowdown, "HwThermal"},
+	{ThrottleHwPowerBrake, "HwPowerBrake"},
+	{ThrottleSyncBoost, "SyncBoost"},
+	{ThrottleApplicationsClocks, "AppClocks"},
+	{ThrottleDisplayClocks, "DisplayClocks"},
+}
+
+// ThrottlingMask selects reasons that actually slow down a running job
+const ThrottlingMask = ThrottleSwPowerCap | ThrottleHwSlowdown | ThrottleSwThermalSlowdown |
+	ThrottleHwThermalSlowdown | ThrottleHwPowerBrake
+
+func ThrottleReasonNames(reasons uint64) []string {
+	names := []string{}
+	for _, entry := range throttleNames {
+		if reasons&entry.bit != 0 {
+			names = append(names, entry.name)
+		}
+	}
+	return names
 }
 
 func (m *GPUMetric) Name() string {
@@ -39,6 +99,114 @@ type GPUSummaryMetric struct {
 	Energy    float64
 	MaxTemp   float64
 	Name      string
+	MigUUIDs  []string
+	Devices   map[int]GPUDeviceSummary
+
+	UtilStats   Distribution
+	MemoryStats Distribution
+	PowerStats  Distribution
+
+	AvgSMClock      float64
+	AvgMemClock     float64
+	AvgMemBandwidth float64
+	AvgPcieTx       float64
+	AvgPcieRx       float64
+	ThrottleReasons uint64
+	ThrottledTime   float64
+	EccCorrected    uint64
+	EccUncorrected  uint64
+	MaxFanSpeed     float64
+}
+
+func (m GPUSummaryMetric) JobName() string {
+	return m.Name
+}
+
+func (m GPUSummaryMetric) Span() (time.Time, time.Time) {
+	return m.Start, m.End
+}
+
+// GPUDeviceSummary describes a single device used by a job. Device state is
+// shared by all processes of the job, so each sample time is counted once.
+type GPUDeviceSummary struct {
+	End         time.Time
+	Duration    float64
+	AvgUtil     float64
+	AvgMemory   float64
+	MemoryTotal float64
+	IdleTime    float64
+}
+
+func AggregateDevices(start time.Time, before map[int]GPUDeviceSummary, metrics []GPUMetric) map[int]GPUDeviceSummary {
+	after := make(map[int]GPUDeviceSummary, len(before))
+	for device, summary := range before {
+		after[device] = summary
+	}
+
+	// MIG instances of a device are sampled at the same time, their memory
+	// is summed and their utilisation averaged
+	grouped := make(map[int]map[time.Time]GPUMetric)
+	instances := make(map[int]map[time.Time][]string)
+	for _, metric := range metrics {
+		if grouped[metric.Device] == nil {
+			grouped[metric.Device] = make(map[time.Time]GPUMetric)
+			instances[metric.Device] = make(map[time.Time][]string)
+		}
+		seen := instances[metric.Device][metric.Time]
+		if metric.MigUUID == "" || len(seen) == 0 {
+			grouped[metric.Device][metric.Time] = metric
+			instances[metric.Device][metric.Time] = []string{metric.MigUUID}
+			continue
+		}
+		if slices.Contains(seen, metric.MigUUID) {
+			continue
+		}
+		merged := grouped[metric.Device][metric.Time]
+		count := float64(len(seen))
+		merged.Util = (merged.Util*count + metric.Util) / (count + 1)
+		merged.Memory += metric.Memory
+		merged.MemoryTotal += metric.MemoryTotal
+		grouped[metric.Device][metric.Time] = merged
+		instances[metric.Device][metric.Time] = append(seen, metric.MigUUID)
+	}
+
+	for device, samples := range grouped {
+		times := make([]time.Time, 0, len(samples))
+		for t := range samples {
+			times = append(times, t)
+		}
+		slices.SortFunc(times, func(a, b time.Time) int { return a.Compare(b) })
+
+		summary := after[device]
+		accumulatedUtil := summary.AvgUtil * summary.Duration
+		accumulatedMemory := summary.AvgMemory * summary.Duration
+		previous := summary.End
+		if previous.IsZero() {
+			previous = start
+		}
+		for _, t := range times {
+			metric := samples[t]
+			timeDelta := t.Sub(previous).Seconds()
+			previous = t
+			if timeDelta <= 0 {
+				continue
+			}
+			summary.Duration += timeDelta
+			accumulatedUtil += metric.Util * timeDelta
+			accumulatedMemory += metric.Memory * timeDelta
+			if metric.Util == 0 {
+				summary.IdleTime += timeDelta
+			}
+			summary.MemoryTotal = max(summary.MemoryTotal, metric.MemoryTotal)
+		}
+		summary.End = previous
+		if summary.Duration > 0 {
+			summary.AvgUtil = accumulatedUtil / summary.Duration
+			summary.AvgMemory = accumulatedMemory / summary.Duration
+		}
+		after[device] = summary
+	}
+	return after
 }
 
 func AggregateUniqueGPU(before GPUSummaryMetric, metrics []GPUMetric) GPUSummaryMetric {
@@ -54,6 +222,46 @@ func AggregateUniqueGPU(before GPUSummaryMetric, metrics []GPUMetric) GPUSummary
 	accumulatedMemory := before.AvgMemory * previousDuration
 	maxTemp := before.MaxTemp
 	totalPower := before.Energy
+	accumulatedSMClock := before.AvgSMClock * previousDuration
+	accumulatedMemClock := before.AvgMemClock * previousDuration
+	accumulatedMemBandwidth := before.AvgMemBandwidth * previousDuration
+	accumulatedPcieTx := before.AvgPcieTx * previousDuration
+	accumulatedPcieRx := before.AvgPcieRx * previousDuration
+	throttleReasons := before.ThrottleReasons
+	throttledTime := before.ThrottledTime
+	eccCorrected := before.EccCorrected
+	eccUncorrected := before.EccUncorrected
+	maxFan := before.MaxFanSpeed
+	migUUIDs := slices.Clone(before.MigUUIDs)
+	utilStats := before.UtilStats.Clone()
+	memoryStats := before.MemoryStats.Clone()
+	powerStats := before.PowerStats.Clone()
+
+	// Device state is shared by processes, count each device sample once.
+	// MIG instances of a device share the sweep time but not their state.
+	type deviceSample struct {
+		device int
+		mig    string
+		time   time.Time
+	}
+	devices := make(map[deviceSample]bool)
+	instances := make(map[deviceSample]bool)
+	sweeps := make(map[time.Time][]GPUMetric)
+	for _, metric := range metrics {
+		device := deviceSample{device: metric.Device, time: metric.Time}
+		if !devices[device] {
+			devices[device] = true
+			sweeps[metric.Time] = append(sweeps[metric.Time], metric)
+		}
+		instance := deviceSample{metric.Device, metric.MigUUID, metric.Time}
+		if instances[instance] {
+			continue
+		}
+		instances[instance] = true
+		utilStats.Add(metric.Util)
+		memoryStats.Add(metric.Memory)
+		powerStats.Add(metric.PowerW / 1000) // mW to W
+	}
 
 	grouped := make(map[int32][]GPUMetric)
 	for _, metric := range metrics {
@@ -66,6 +274,32 @@ func AggregateUniqueGPU(before GPUSummaryMetric, metrics []GPUMetric) GPUSummary
 		startTime = before.Start
 	}
 
+	// Clocks, bandwidth and PCIe traffic belong to the device, a sweep counts
+	// them once per device: clocks and bandwidth are averaged over the devices,
+	// traffic is summed and the sweep is throttled if any device is
+	previous := startTime
+	for _, t := range slices.SortedFunc(maps.Keys(sweeps), time.Time.Compare) {
+		timeDelta := t.Sub(previous).Seconds()
+		previous = t
+		devices := float64(len(sweeps[t]))
+		throttled := false
+		for _, metric := range sweeps[t] {
+			accumulatedSMClock += metric.SMClock * timeDelta / devices
+			accumulatedMemClock += metric.MemClock * timeDelta / devices
+			accumulatedMemBandwidth += metric.MemBandwidth * timeDelta / devices
+			accumulatedPcieTx += metric.PcieTx * timeDelta
+			accumulatedPcieRx += metric.PcieRx * timeDelta
+			throttleReasons |= metric.ThrottleReasons
+			throttled = throttled || metric.ThrottleReasons&ThrottlingMask != 0
+			eccCorrected = max(eccCorrected, metric.EccCorrected)
+			eccUncorrected = max(eccUncorrected, metric.EccUncorrected)
+			maxFan = max(maxFan, metric.FanSpeed)
+		}
+		if throttled {
+			throttledTime += timeDelta
+		}
+	}
+
 	for _, metricGroup := range grouped {
 		if len(metricGroup) == 0 {
 			continue
@@ -89,6 +323,10 @@ func AggregateUniqueGPU(before GPUSummaryMetric, metrics []GPUMetric) GPUSummary
 			accumulatedUtil += metric.Util * timeDelta
 			accumulatedMemory += metric.Memory * timeDelta
 			totalPower += metric.PowerW * timeDelta / (3600 * 1000) //MW and s to W and h conversion
+
+			if metric.MigUUID != "" && !slices.Contains(migUUIDs, metric.MigUUID) {
+				migUUIDs = append(migUUIDs, metric.MigUUID)
+			}
 		}
 	}
 
@@ -103,5 +341,22 @@ func AggregateUniqueGPU(before GPUSummaryMetric, metrics []GPUMetric) GPUSummary
 		MaxTemp:   maxTemp,
 		Energy:    totalPower,
 		Name:      before.Name,
+		MigUUIDs:  migUUIDs,
+		Devices:   AggregateDevices(before.Start, before.Devices, metrics),
+
+		UtilStats:   utilStats,
+		MemoryStats: memoryStats,
+		PowerStats:  powerStats,
+
+		AvgSMClock:      accumulatedSMClock / totalDuration,
+		AvgMemClock:     accumulatedMemClock / totalDuration,
+		AvgMemBandwidth: accumulatedMemBandwidth / totalDuration,
+		AvgPcieTx:       accumulatedPcieTx / totalDuration,
+		AvgPcieRx:       accumulatedPcieRx / totalDuration,
+		ThrottleReasons: throttleReasons,
+		ThrottledTime:   throttledTime,
+		EccCorrected:    eccCorrected,
+		EccUncorrected:  eccUncorrected,
+		MaxFanSpeed:     maxFan,
 	}
 }
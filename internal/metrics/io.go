@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"time"
+)
+
+type IOMetric struct {
+	Pid_id     int32
+	PPID       int32
+	ReadBytes  uint64
+	WriteBytes uint64
+	ReadCalls  uint64
+	WriteCalls uint64
+	ReadRate   float64
+	WriteRate  float64
+	Time       time.Time
+}
+
+func (m *IOMetric) Pid() int32 {
+	return m.Pid_id
+}
+
+func (m *IOMetric) PPid() int32 {
+	return m.PPID
+}
+
+func (m *IOMetric) Timestamp() time.Time {
+	return m.Time
+}
+
+type IOSummaryMetric struct {
+	Start         time.Time
+	End           time.Time
+	ReadBytes     uint64
+	WriteBytes    uint64
+	ReadCalls     uint64
+	WriteCalls    uint64
+	PeakReadRate  float64
+	PeakWriteRate float64
+	Name          string
+}
+
+func (m IOSummaryMetric) JobName() string {
+	return m.Name
+}
+
+func (m IOSummaryMetric) Span() (time.Time, time.Time) {
+	return m.Start, m.End
+}
+
+// AggregateUniqueIO sums per-sample deltas, peak throughput is the job-wide
+// rate, i.e. the sum over all processes sampled at the same time.
+func AggregateUniqueIO(before IOSummaryMetric, metrics []IOMetric) IOSummaryMetric {
+	if len(metrics) == 0 {
+		return before
+	}
+
+	after := before
+	readRates := make(map[time.Time]float64)
+	writeRates := make(map[time.Time]float64)
+
+	for _, metric := range metrics {
+		if metric.Time.After(after.End) {
+			after.End = metric.Time
+		}
+		after.ReadBytes += metric.ReadBytes
+		after.WriteBytes += metric.WriteBytes
+		after.ReadCalls += metric.ReadCalls
+		after.WriteCalls += metric.WriteCalls
+		readRates[metric.Time] += metric.ReadRate
+		writeRates[metric.Time] += metric.WriteRate
+	}
+
+	for _, rate := range readRates {
+		after.PeakReadRate = max(after.PeakReadRate, rate)
+	}
+	for _, rate := range writeRates {
+		after.PeakWriteRate = max(after.PeakWriteRate, rate)
+	}
+	return after
+}
@@ -0,0 +1,225 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Summary is the state a kind keeps for every job
+type Summary interface {
+	JobName() string
+	Span() (start, end time.Time)
+}
+
+// View is a table of `met list`. The job and its name come first, the
+// columns of the view next and, when asked for, the status and duration.
+type View struct {
+	Columns []string
+	// With lists kinds whose summaries of the same job the rows also need
+	With     []string
+	Status   bool
+	Duration bool
+	// Rows renders the summary of a job, with holds the summaries of the kinds
+	// in With and args the arguments following the kind on the command line
+	Rows func(summary Summary, with map[string]Summary, args []string) [][]string
+}
+
+// Kind ties samples of one type to the summary storage keeps for them and to
+// the way they are shown, storage, the protocol, the API and `met list` only
+// go through the registry, so a new kind is added by registering it.
+type Kind struct {
+	Name string
+	// Sample tags raw samples of the kind in series and subscriptions,
+	// Name when empty
+	Sample string
+	// Views of `met list`, "" is the default one, the others are picked by
+	// the flag of their name
+	Views map[string]View
+	// Describe renders a single sample for `met watch`
+	Describe func(Metric) string
+
+	empty     func(start time.Time, name string) Summary
+	aggregate func(Summary, []Metric) Summary
+	owns      func(Metric) bool
+	newSample func() Metric
+	decode    func([]byte) (Summary, error)
+}
+
+// NewKind describes a kind whose samples are *T and summaries S, aggregate
+// folds a batch of samples of one job into its summary.
+func NewKind[S Summary, T any, P interface {
+	*T
+	Metric
+}](name string, empty func(start time.Time, name string) S, aggregate func(S, []T) S) *Kind {
+	return &Kind{
+		Name:  name,
+		Views: map[string]View{},
+		empty: func(start time.Time, name string) Summary {
+			return empty(start, name)
+		},
+		aggregate: func(before Summary, samples []Metric) Summary {
+			values := make([]T, 0, len(samples))
+			for _, sample := range samples {
+				values = append(values, *sample.(P))
+			}
+			return aggregate(before.(S), values)
+		},
+		owns: func(metric Metric) bool {
+			_, ok := metric.(P)
+			return ok
+		},
+		newSample: func() Metric {
+			return P(new(T))
+		},
+		decode: func(data []byte) (Summary, error) {
+			var summary S
+			err := json.Unmarshal(data, &summary)
+			return summary, err
+		},
+	}
+}
+
+// Row adapts a renderer of a single row per job to View.Rows
+func Row[S Summary](row func(S) []string) func(Summary, map[string]Summary, []string) [][]string {
+	return func(summary Summary, _ map[string]Summary, _ []string) [][]string {
+		return [][]string{row(summary.(S))}
+	}
+}
+
+func (k *Kind) SampleName() string {
+	if k.Sample != "" {
+		return k.Sample
+	}
+	return k.Name
+}
+
+// NewSummary returns the summary of a job that has not been sampled yet
+func (k *Kind) NewSummary(start time.Time, name string) Summary {
+	return k.empty(start, name)
+}
+
+// Aggregate folds samples of the kind belonging to one job into before
+func (k *Kind) Aggregate(before Summary, samples []Metric) Summary {
+	if before == nil {
+		before = k.empty(time.Time{}, "")
+	}
+	return k.aggregate(before, samples)
+}
+
+func (k *Kind) Owns(metric Metric) bool {
+	return k.owns(metric)
+}
+
+// NewSample returns an empty sample of the kind to decode into
+func (k *Kind) NewSample() Metric {
+	return k.newSample()
+}
+
+// DecodeSummary restores a summary of the kind sent by a daemon
+func (k *Kind) DecodeSummary(data []byte) (Summary, error) {
+	return k.decode(data)
+}
+
+func (k *Kind) DescribeSample(metric Metric) string {
+	if k.Describe == nil {
+		return k.SampleName()
+	}
+	return k.Describe(metric)
+}
+
+var kinds = struct {
+	sync.RWMutex
+	ordered []*Kind
+}{}
+
+// RegisterKind adds a kind, or replaces the kind of the same name. Kinds
+// are registered before the daemon starts, from init functions.
+func RegisterKind(kind *Kind) {
+	kinds.Lock()
+	defer kinds.Unlock()
+	for i, registered := range kinds.ordered {
+		if registered.Name == kind.Name {
+			kinds.ordered[i] = kind
+			return
+		}
+	}
+	kinds.ordered = append(kinds.ordered, kind)
+}
+
+// Kinds lists the registered kinds in the order of registration
+func Kinds() []*Kind {
+	kinds.RLock()
+	defer kinds.RUnlock()
+	return append([]*Kind{}, kinds.ordered...)
+}
+
+func KindNames() []string {
+	names := []string{}
+	for _, kind := range Kinds() {
+		names = append(names, kind.Name)
+	}
+	return names
+}
+
+func LookupKind(name string) (*Kind, bool) {
+	for _, kind := range Kinds() {
+		if kind.Name == name {
+			return kind, true
+		}
+	}
+	return nil, false
+}
+
+// LookupSample finds the kind of raw samples tagged name
+func LookupSample(name string) (*Kind, bool) {
+	for _, kind := range Kinds() {
+		if kind.SampleName() == name {
+			return kind, true
+		}
+	}
+	return nil, false
+}
+
+// KindOf finds the kind a sample belongs to
+func KindOf(metric Metric) (*Kind, bool) {
+	for _, kind := range Kinds() {
+		if kind.Owns(metric) {
+			return kind, true
+		}
+	}
+	return nil, false
+}
+
+// unitFormats renders values of a unit, values of other units are printed
+// with two decimals followed by the unit
+var unitFormats = struct {
+	sync.RWMutex
+	byUnit map[string]func(float64) string
+}{byUnit: map[string]func(float64) string{
+	"B":   FormatBytes,
+	"B/s": func(value float64) string { return FormatBytes(value) + "/s" },
+	"%":   func(value float64) string { return fmt.Sprintf("%.1f%%", value) },
+	"s": func(value float64) string {
+		return time.Duration(value * float64(time.Second)).Truncate(time.Millisecond).String()
+	},
+}}
+
+// RegisterUnit sets how values of unit are rendered
+func RegisterUnit(unit string, format func(float64) string) {
+	unitFormats.Lock()
+	defer unitFormats.Unlock()
+	unitFormats.byUnit[unit] = format
+}
+
+func FormatValue(value float64, unit string) string {
+	unitFormats.RLock()
+	format, ok := unitFormats.byUnit[unit]
+	unitFormats.RUnlock()
+	if ok {
+		return format(value)
+	}
+	return strings.TrimSpace(fmt.Sprintf("%.2f %s", value, unit))
+}
@@ -0,0 +1,274 @@
+package metrics
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Built-in kinds, registered in init like kinds of any other package
+const (
+	KindCPU     = "cpu"
+	KindGPU     = "gpu"
+	KindIO      = "io"
+	KindNet     = "net"
+	KindEnergy  = "energy"
+	KindMetrics = "metrics"
+)
+
+// StatsColumns head views rendering rows of StatsRow
+var StatsColumns = []string{"Metric", "Samples", "Min", "Mean", "Std", "P50", "P95", "P99", "Max"}
+
+// StatsRow renders the distribution of a metric, values are formatted by format
+func StatsRow(name string, dist Distribution, format func(float64) string) []string {
+	return []string{
+		name,
+		fmt.Sprintf("%d", dist.Count),
+		format(dist.Min),
+		format(dist.Mean),
+		format(dist.Std()),
+		format(dist.Quantile(0.5)),
+		format(dist.Quantile(0.95)),
+		format(dist.Quantile(0.99)),
+		format(dist.Max),
+	}
+}
+
+func percent(value float64) string {
+	return fmt.Sprintf("%.2f%%", value)
+}
+
+func cpuKind() *Kind {
+	kind := NewKind(KindCPU, func(start time.Time, name string) CPUSummaryMetric {
+		return CPUSummaryMetric{Start: start, Name: name}
+	}, AggregateUniqueCPU)
+	kind.Views[""] = View{
+		Columns:  []string{"CPU % (AVG)", "MEM % (AVG)", "RSS (AVG)", "RSS (PEAK)", "PSS (PEAK)", "VMS (PEAK)", "SWAP (PEAK)"},
+		Status:   true,
+		Duration: true,
+		Rows: Row(func(metric CPUSummaryMetric) []string {
+			pss := "-"
+			if metric.PeakPSS > 0 {
+				pss = FormatBytes(float64(metric.PeakPSS))
+			}
+			return []string{
+				fmt.Sprintf("%.2f%%", metric.CPU),
+				fmt.Sprintf("%.2f%%", metric.Memory),
+				FormatBytes(metric.AvgRSS),
+				FormatBytes(float64(metric.PeakRSS)),
+				pss,
+				FormatBytes(float64(metric.PeakVMS)),
+				FormatBytes(float64(metric.PeakSwap)),
+			}
+		}),
+	}
+	kind.Views["stats"] = View{
+		Columns: StatsColumns,
+		Rows: func(summary Summary, _ map[string]Summary, _ []string) [][]string {
+			metric := summary.(CPUSummaryMetric)
+			return [][]string{
+				StatsRow("CPU", metric.CPUStats, percent),
+				StatsRow("RSS", metric.MemoryStats, FormatBytes),
+			}
+		},
+	}
+	kind.Describe = func(sample Metric) string {
+		m := sample.(*CPUMetric)
+		return fmt.Sprintf("cpu pid %d: %.1f%% CPU, RSS %s", m.Pid_id, m.CPU, FormatBytes(float64(m.RSS)))
+	}
+	return kind
+}
+
+func gpuKind() *Kind {
+	kind := NewKind(KindGPU, func(start time.Time, name string) GPUSummaryMetric {
+		return GPUSummaryMetric{Start: start, Name: name}
+	}, AggregateUniqueGPU)
+	kind.Views[""] = View{
+		Columns:  []string{"GPU Util (AVG)", "MEM (AVG)", "Total power", "Max Temp"},
+		Status:   true,
+		Duration: true,
+		Rows: Row(func(metric GPUSummaryMetric) []string {
+			return []string{
+				fmt.Sprintf("%.2f%%", metric.AvgUtil),
+				fmt.Sprintf("%.2f GB", metric.AvgMemory),
+				fmt.Sprintf("%.2f Wh", metric.Energy),
+				fmt.Sprintf("%.2f C", metric.MaxTemp),
+			}
+		}),
+	}
+	kind.Views["extended"] = View{
+		Columns: []string{"SM Clock (AVG)", "MEM Clock (AVG)", "MEM BW (AVG)", "PCIe TX/RX (AVG)", "Throttled", "Throttle Reasons", "ECC Corr/Uncorr", "Max Fan", "MIG"},
+		Rows: Row(func(metric GPUSummaryMetric) []string {
+			var throttled float64
+			if !metric.End.IsZero() && metric.End.After(metric.Start) {
+				throttled = 100 * metric.ThrottledTime / metric.End.Sub(metric.Start).Seconds()
+			}
+			reasons := strings.Join(ThrottleReasonNames(metric.ThrottleReasons), ",")
+			if reasons == "" {
+				reasons = "-"
+			}
+			mig := strings.Join(metric.MigUUIDs, "\n")
+			if mig == "" {
+				mig = "-"
+			}
+			return []string{
+				fmt.Sprintf("%.0f MHz", metric.AvgSMClock),
+				fmt.Sprintf("%.0f MHz", metric.AvgMemClock),
+				fmt.Sprintf("%.2f%%", metric.AvgMemBandwidth),
+				fmt.Sprintf("%.1f/%.1f MB/s", metric.AvgPcieTx, metric.AvgPcieRx),
+				fmt.Sprintf("%.2f%%", throttled),
+				reasons,
+				fmt.Sprintf("%d/%d", metric.EccCorrected, metric.EccUncorrected),
+				fmt.Sprintf("%.0f%%", metric.MaxFanSpeed),
+				mig,
+			}
+		}),
+	}
+	kind.Views["stats"] = View{
+		Columns: StatsColumns,
+		Rows: func(summary Summary, _ map[string]Summary, _ []string) [][]string {
+			metric := summary.(GPUSummaryMetric)
+			return [][]string{
+				StatsRow("GPU Util", metric.UtilStats, percent),
+				StatsRow("GPU MEM", metric.MemoryStats, func(v float64) string { return fmt.Sprintf("%.2f GB", v) }),
+				StatsRow("Power", metric.PowerStats, func(v float64) string { return fmt.Sprintf("%.1f W", v) }),
+			}
+		},
+	}
+	kind.Describe = func(sample Metric) string {
+		m := sample.(*GPUMetric)
+		return fmt.Sprintf("gpu pid %d: GPU %d util %.1f%%, memory %.2f GB, %.1f W", m.Pid_id, m.Device, m.Util, m.Memory, m.PowerW)
+	}
+	return kind
+}
+
+func ioKind() *Kind {
+	kind := NewKind(KindIO, func(start time.Time, name string) IOSummaryMetric {
+		return IOSummaryMetric{Start: start, Name: name}
+	}, AggregateUniqueIO)
+	kind.Views[""] = View{
+		Columns:  []string{"Read", "Written", "Read Calls", "Write Calls", "Peak Read", "Peak Write"},
+		Status:   true,
+		Duration: true,
+		Rows: Row(func(metric IOSummaryMetric) []string {
+			return []string{
+				FormatBytes(float64(metric.ReadBytes)),
+				FormatBytes(float64(metric.WriteBytes)),
+				fmt.Sprintf("%d", metric.ReadCalls),
+				fmt.Sprintf("%d", metric.WriteCalls),
+				FormatBytes(metric.PeakReadRate) + "/s",
+				FormatBytes(metric.PeakWriteRate) + "/s",
+			}
+		}),
+	}
+	kind.Describe = func(sample Metric) string {
+		m := sample.(*IOMetric)
+		return fmt.Sprintf("io pid %d: read %s/s, write %s/s", m.Pid_id, FormatBytes(m.ReadRate), FormatBytes(m.WriteRate))
+	}
+	return kind
+}
+
+func netKind() *Kind {
+	kind := NewKind(KindNet, func(start time.Time, name string) NetSummaryMetric {
+		return NetSummaryMetric{Start: start, Name: name}
+	}, AggregateUniqueNet)
+	kind.Views[""] = View{
+		Columns:  []string{"Sent", "Received", "Send Rate (AVG)", "Recv Rate (AVG)", "Peak Send", "Peak Recv"},
+		Status:   true,
+		Duration: true,
+		Rows: Row(func(metric NetSummaryMetric) []string {
+			return []string{
+				FormatBytes(float64(metric.BytesSent)),
+				FormatBytes(float64(metric.BytesRecv)),
+				FormatBytes(metric.AvgSendRate()) + "/s",
+				FormatBytes(metric.AvgRecvRate()) + "/s",
+				FormatBytes(metric.PeakSendRate) + "/s",
+				FormatBytes(metric.PeakRecvRate) + "/s",
+			}
+		}),
+	}
+	kind.Describe = func(sample Metric) string {
+		m := sample.(*NetMetric)
+		return fmt.Sprintf("net pid %d: sent %s/s, received %s/s", m.Pid_id, FormatBytes(m.SendRate), FormatBytes(m.RecvRate))
+	}
+	return kind
+}
+
+func energyKind() *Kind {
+	kind := NewKind(KindEnergy, func(start time.Time, name string) EnergySummaryMetric {
+		return EnergySummaryMetric{Start: start, Name: name}
+	}, AggregateUniqueEnergy)
+	kind.Views[""] = View{
+		Columns: []string{"CPU Package", "DRAM", "CPU Energy", "GPU Energy", "Total"},
+		With:    []string{KindGPU},
+		Status:  true,
+		Rows: func(summary Summary, with map[string]Summary, _ []string) [][]string {
+			cpuMetric := summary.(EnergySummaryMetric)
+			gpuMetric, _ := with[KindGPU].(GPUSummaryMetric)
+			return [][]string{{
+				fmt.Sprintf("%.2f Wh", cpuMetric.PackageWh),
+				fmt.Sprintf("%.2f Wh", cpuMetric.DramWh),
+				fmt.Sprintf("%.2f Wh", cpuMetric.TotalWh()),
+				fmt.Sprintf("%.2f Wh", gpuMetric.Energy),
+				fmt.Sprintf("%.2f Wh", cpuMetric.TotalWh()+gpuMetric.Energy),
+			}}
+		},
+	}
+	kind.Describe = func(sample Metric) string {
+		m := sample.(*EnergyMetric)
+		return fmt.Sprintf("energy pid %d: package %.2f J, dram %.2f J", m.Pid_id, m.PackageJ, m.DramJ)
+	}
+	return kind
+}
+
+// metricsKind shows one row per series, only series whose name starts with
+// the argument following the kind are shown
+func metricsKind() *Kind {
+	kind := NewKind(KindMetrics, func(start time.Time, name string) MetricsSummary {
+		return MetricsSummary{Start: start, Name: name}
+	}, AggregateUniqueSamples)
+	kind.Sample = "metric"
+	kind.Views[""] = View{
+		Columns: []string{"Metric", "Aggregation", "Value", "Last", "Min", "Max"},
+		Status:  true,
+		Rows: func(summary Summary, _ map[string]Summary, args []string) [][]string {
+			metric := summary.(MetricsSummary)
+			prefix := ""
+			if len(args) > 0 {
+				prefix = args[0]
+			}
+			rows := [][]string{}
+			for _, name := range slices.Sorted(maps.Keys(metric.Series)) {
+				series := metric.Series[name]
+				if !strings.HasPrefix(series.Name, prefix) {
+					continue
+				}
+				rows = append(rows, []string{
+					name,
+					string(series.Aggregation),
+					FormatValue(series.Value, series.Unit),
+					FormatValue(series.Last, series.Unit),
+					FormatValue(series.Min, series.Unit),
+					FormatValue(series.Max, series.Unit),
+				})
+			}
+			return rows
+		},
+	}
+	kind.Describe = func(sample Metric) string {
+		m := sample.(*Sample)
+		return fmt.Sprintf("metric pid %d: %s %s", m.Pid_id, m.Key(), FormatValue(m.Value, m.Unit))
+	}
+	return kind
+}
+
+func init() {
+	RegisterKind(cpuKind())
+	RegisterKind(gpuKind())
+	RegisterKind(ioKind())
+	RegisterKind(netKind())
+	RegisterKind(energyKind())
+	RegisterKind(metricsKind())
+}
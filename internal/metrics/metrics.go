@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -11,11 +12,18 @@ type Metric interface {
 }
 
 type CPUMetric struct {
-	Pid_id int32
-	PPID   int32
-	CPU    float64
-	Memory float64
-	Time   time.Time
+	Pid_id  int32
+	PPID    int32
+	CPU     float64
+	Memory  float64
+	RSS     uint64
+	VMS     uint64
+	Swap    uint64
+	PSS     uint64
+	Time    time.Time
+	Parent  int32
+	Cmdline string `json:",omitempty"`
+	Created time.Time
 }
 
 func (m *CPUMetric) Pid() int32 {
@@ -30,11 +38,27 @@ func (m *CPUMetric) Timestamp() time.Time {
 }
 
 type CPUSummaryMetric struct {
-	Start  time.Time
-	End    time.Time
-	CPU    float64
-	Memory float64
-	Name   string
+	Start    time.Time
+	End      time.Time
+	CPU      float64
+	Memory   float64
+	AvgRSS   float64
+	PeakRSS  uint64
+	PeakVMS  uint64
+	PeakSwap uint64
+	PeakPSS  uint64
+	Name     string
+
+	CPUStats    Distribution
+	MemoryStats Distribution
+}
+
+func (m CPUSummaryMetric) JobName() string {
+	return m.Name
+}
+
+func (m CPUSummaryMetric) Span() (time.Time, time.Time) {
+	return m.Start, m.End
 }
 
 func AggregateUniqueCPU(before CPUSummaryMetric, metrics []CPUMetric) CPUSummaryMetric {
@@ -48,12 +72,40 @@ func AggregateUniqueCPU(before CPUSummaryMetric, metrics []CPUMetric) CPUSummary
 	}
 	accumulatedCPU := before.CPU * previousDuration
 	accumulatedMemory := before.Memory * previousDuration
+	accumulatedRSS := before.AvgRSS * previousDuration
 
 	grouped := make(map[int32][]CPUMetric)
 	for _, metric := range metrics {
 		grouped[metric.Pid()] = append(grouped[metric.Pid()], metric)
 	}
 
+	// Job memory at a given moment is the sum over the process tree,
+	// collectors stamp a whole sweep with the same time.
+	totals := make(map[time.Time]*CPUMetric)
+	for _, metric := range metrics {
+		total, ok := totals[metric.Time]
+		if !ok {
+			total = &CPUMetric{Time: metric.Time}
+			totals[metric.Time] = total
+		}
+		total.CPU += metric.CPU
+		total.RSS += metric.RSS
+		total.VMS += metric.VMS
+		total.Swap += metric.Swap
+		total.PSS += metric.PSS
+	}
+	peakRSS, peakVMS, peakSwap, peakPSS := before.PeakRSS, before.PeakVMS, before.PeakSwap, before.PeakPSS
+	cpuStats := before.CPUStats.Clone()
+	memoryStats := before.MemoryStats.Clone()
+	for _, total := range totals {
+		cpuStats.Add(total.CPU)
+		memoryStats.Add(float64(total.RSS))
+		peakRSS = max(peakRSS, total.RSS)
+		peakVMS = max(peakVMS, total.VMS)
+		peakSwap = max(peakSwap, total.Swap)
+		peakPSS = max(peakPSS, total.PSS)
+	}
+
 	var latestTime time.Time
 	startTime := before.End
 	if startTime.IsZero() {
@@ -79,6 +131,7 @@ func AggregateUniqueCPU(before CPUSummaryMetric, metrics []CPUMetric) CPUSummary
 
 			accumulatedCPU += metric.CPU * timeDelta
 			accumulatedMemory += metric.Memory * timeDelta
+			accumulatedRSS += float64(metric.RSS) * timeDelta
 		}
 	}
 
@@ -86,10 +139,29 @@ func AggregateUniqueCPU(before CPUSummaryMetric, metrics []CPUMetric) CPUSummary
 	avgCPU := accumulatedCPU / totalDuration
 	avgMemory := accumulatedMemory / totalDuration
 	return CPUSummaryMetric{
-		Start:  before.Start,
-		End:    latestTime,
-		CPU:    avgCPU,
-		Memory: avgMemory,
-		Name:   before.Name,
+		Start:    before.Start,
+		End:      latestTime,
+		CPU:      avgCPU,
+		Memory:   avgMemory,
+		AvgRSS:   accumulatedRSS / totalDuration,
+		PeakRSS:  peakRSS,
+		PeakVMS:  peakVMS,
+		PeakSwap: peakSwap,
+		PeakPSS:  peakPSS,
+		Name:     before.Name,
+
+		CPUStats:    cpuStats,
+		MemoryStats: memoryStats,
+	}
+}
+
+// FormatBytes renders a size with a binary unit, e.g. 1.50 GB
+func FormatBytes(bytes float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	unit := 0
+	for bytes >= 1024 && unit < len(units)-1 {
+		bytes /= 1024
+		unit++
 	}
+	return fmt.Sprintf("%.2f %s", bytes, units[unit])
 }
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"time"
+)
+
+type NetMetric struct {
+	Pid_id    int32
+	PPID      int32
+	BytesSent uint64
+	BytesRecv uint64
+	SendRate  float64
+	RecvRate  float64
+	Time      time.Time
+}
+
+func (m *NetMetric) Pid() int32 {
+	return m.Pid_id
+}
+
+func (m *NetMetric) PPid() int32 {
+	return m.PPID
+}
+
+func (m *NetMetric) Timestamp() time.Time {
+	return m.Time
+}
+
+type NetSummaryMetric struct {
+	Start        time.Time
+	End          time.Time
+	BytesSent    uint64
+	BytesRecv    uint64
+	PeakSendRate float64
+	PeakRecvRate float64
+	Name         string
+}
+
+func (m NetSummaryMetric) JobName() string {
+	return m.Name
+}
+
+func (m NetSummaryMetric) Span() (time.Time, time.Time) {
+	return m.Start, m.End
+}
+
+func AggregateUniqueNet(before NetSummaryMetric, metrics []NetMetric) NetSummaryMetric {
+	if len(metrics) == 0 {
+		return before
+	}
+
+	after := before
+	sendRates := make(map[time.Time]float64)
+	recvRates := make(map[time.Time]float64)
+
+	for _, metric := range metrics {
+		if metric.Time.After(after.End) {
+			after.End = metric.Time
+		}
+		after.BytesSent += metric.BytesSent
+		after.BytesRecv += metric.BytesRecv
+		sendRates[metric.Time] += metric.SendRate
+		recvRates[metric.Time] += metric.RecvRate
+	}
+
+	for _, rate := range sendRates {
+		after.PeakSendRate = max(after.PeakSendRate, rate)
+	}
+	for _, rate := range recvRates {
+		after.PeakRecvRate = max(after.PeakRecvRate, rate)
+	}
+	return after
+}
+
+// AvgSendRate and AvgRecvRate return bytes per second over the job lifetime
+func (m NetSummaryMetric) AvgSendRate() float64 {
+	return rateOver(m.BytesSent, m.Start, m.End)
+}
+
+func (m NetSummaryMetric) AvgRecvRate() float64 {
+	return rateOver(m.BytesRecv, m.Start, m.End)
+}
+
+func rateOver(bytes uint64, start, end time.Time) float64 {
+	if end.IsZero() || !end.After(start) {
+		return 0
+	}
+	return float64(bytes) / end.Sub(start).Seconds()
+}
@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"time"
+)
+
+// ProcessSummary is a per-process view of a job, kept alongside job-level
+// summaries so multi-process jobs (MPI ranks, dataloader workers) can be
+// inspected individually.
+type ProcessSummary struct {
+	Pid       int32
+	Parent    int32
+	Cmdline   string
+	Start     time.Time
+	End       time.Time
+	CPU       float64
+	Memory    float64
+	AvgRSS    float64
+	PeakRSS   uint64
+	GPUUtil   float64
+	GPUMemory float64
+
+	CPUEnd      time.Time
+	CPUDuration float64
+	GPUEnd      time.Time
+	GPUDuration float64
+}
+
+func newProcessSummary(pid int32, created time.Time, jobStart time.Time) ProcessSummary {
+	if created.IsZero() || created.Before(jobStart) {
+		created = jobStart
+	}
+	return ProcessSummary{Pid: pid, Start: created}
+}
+
+func AggregateProcesses(jobStart time.Time, before map[int32]ProcessSummary, cpuMetrics []CPUMetric, gpuMetrics []GPUMetric) map[int32]ProcessSummary {
+	after := make(map[int32]ProcessSummary, len(before))
+	for pid, summary := range before {
+		after[pid] = summary
+	}
+
+	for _, metric := range cpuMetrics {
+		summary, ok := after[metric.Pid_id]
+		if !ok {
+			summary = newProcessSummary(metric.Pid_id, metric.Created, jobStart)
+		}
+		summary.Parent = metric.Parent
+		if metric.Cmdline != "" {
+			summary.Cmdline = metric.Cmdline
+		}
+
+		previous := summary.CPUEnd
+		if previous.IsZero() {
+			previous = summary.Start
+		}
+		if timeDelta := metric.Time.Sub(previous).Seconds(); timeDelta > 0 {
+			total := summary.CPUDuration + timeDelta
+			summary.CPU = (summary.CPU*summary.CPUDuration + metric.CPU*timeDelta) / total
+			summary.Memory = (summary.Memory*summary.CPUDuration + metric.Memory*timeDelta) / total
+			summary.AvgRSS = (summary.AvgRSS*summary.CPUDuration + float64(metric.RSS)*timeDelta) / total
+			summary.CPUDuration = total
+			summary.CPUEnd = metric.Time
+		}
+		summary.PeakRSS = max(summary.PeakRSS, metric.RSS)
+		if metric.Time.After(summary.End) {
+			summary.End = metric.Time
+		}
+		after[metric.Pid_id] = summary
+	}
+
+	// A process using several GPUs gets the sum of their utilisation
+	type processSample struct {
+		pid  int32
+		time time.Time
+	}
+	util := make(map[processSample]float64)
+	memory := make(map[processSample]float64)
+	for _, metric := range gpuMetrics {
+		key := processSample{metric.Pid_id, metric.Time}
+		util[key] += metric.Util
+		memory[key] += metric.Memory
+	}
+	for _, metric := range gpuMetrics {
+		key := processSample{metric.Pid_id, metric.Time}
+		if _, pending := util[key]; !pending {
+			continue
+		}
+		summary, ok := after[metric.Pid_id]
+		if !ok {
+			summary = newProcessSummary(metric.Pid_id, time.Time{}, jobStart)
+		}
+		previous := summary.GPUEnd
+		if previous.IsZero() {
+			previous = summary.Start
+		}
+		if timeDelta := metric.Time.Sub(previous).Seconds(); timeDelta > 0 {
+			total := summary.GPUDuration + timeDelta
+			summary.GPUUtil = (summary.GPUUtil*summary.GPUDuration + util[key]*timeDelta) / total
+			summary.GPUMemory = (summary.GPUMemory*summary.GPUDuration + memory[key]*timeDelta) / total
+			summary.GPUDuration = total
+			summary.GPUEnd = metric.Time
+		}
+		if metric.Time.After(summary.End) {
+			summary.End = metric.Time
+		}
+		delete(util, key)
+		after[metric.Pid_id] = summary
+	}
+	return after
+}
@@ -0,0 +1,247 @@
+package metrics
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Aggregation tells how the samples of a series are summarised over the life
+// of a job
+type Aggregation string
+
+const (
+	AggregateMean Aggregation = "mean"
+	AggregateMax  Aggregation = "max"
+	AggregateMin  Aggregation = "min"
+	AggregateSum  Aggregation = "sum"
+	AggregateLast Aggregation = "last"
+)
+
+// Aggregator folds value into the aggregate of count earlier values, which
+// cover weight seconds of the job. The value covers span seconds since the
+// previous one, or since the start of the job for the first value.
+type Aggregator func(aggregate float64, count int, weight float64, value float64, span float64) float64
+
+var aggregators = struct {
+	sync.RWMutex
+	byKind map[Aggregation]Aggregator
+}{byKind: map[Aggregation]Aggregator{
+	// the mean is weighted by time like the means of built-in kinds, values
+	// with no time to weigh them are averaged
+	AggregateMean: func(aggregate float64, count int, weight float64, value float64, span float64) float64 {
+		if weight+span <= 0 {
+			return aggregate + (value-aggregate)/float64(count+1)
+		}
+		return (aggregate*weight + value*span) / (weight + span)
+	},
+	AggregateMax: func(aggregate float64, count int, _ float64, value float64, _ float64) float64 {
+		if count == 0 {
+			return value
+		}
+		return max(aggregate, value)
+	},
+	AggregateMin: func(aggregate float64, count int, _ float64, value float64, _ float64) float64 {
+		if count == 0 {
+			return value
+		}
+		return min(aggregate, value)
+	},
+	AggregateSum: func(aggregate float64, _ int, _ float64, value float64, _ float64) float64 {
+		return aggregate + value
+	},
+	AggregateLast: func(_ float64, _ int, _ float64, value float64, _ float64) float64 {
+		return value
+	},
+}}
+
+// RegisterAggregation adds or replaces the aggregator of a kind
+func RegisterAggregation(kind Aggregation, aggregator Aggregator) {
+	aggregators.Lock()
+	defer aggregators.Unlock()
+	aggregators.byKind[kind] = aggregator
+}
+
+// Aggregations lists the registered kinds by name
+func Aggregations() []Aggregation {
+	aggregators.RLock()
+	defer aggregators.RUnlock()
+	return slices.Sorted(maps.Keys(aggregators.byKind))
+}
+
+// aggregatorOf returns the aggregator of kind, series without a known kind
+// are averaged
+func aggregatorOf(kind Aggregation) (Aggregation, Aggregator) {
+	aggregators.RLock()
+	defer aggregators.RUnlock()
+	if aggregator, ok := aggregators.byKind[kind]; ok {
+		return kind, aggregator
+	}
+	return AggregateMean, aggregators.byKind[AggregateMean]
+}
+
+// ValidAggregation tells whether kind is registered, the empty kind stands
+// for the mean
+func ValidAggregation(kind Aggregation) bool {
+	if kind == "" {
+		return true
+	}
+	aggregators.RLock()
+	defer aggregators.RUnlock()
+	_, ok := aggregators.byKind[kind]
+	return ok
+}
+
+// Sample is a single value of a generic metric. Labels tell apart series of
+// one metric, e.g. {"device": "0"}, Unit and Aggregation tell storage and
+// display how to summarise and render it, so new metrics need no new types.
+type Sample struct {
+	Pid_id      int32
+	PPID        int32
+	Name        string
+	Unit        string            `json:",omitempty"`
+	Labels      map[string]string `json:",omitempty"`
+	Aggregation Aggregation       `json:",omitempty"`
+	Value       float64
+	Time        time.Time
+}
+
+func (m *Sample) Pid() int32 {
+	return m.Pid_id
+}
+
+func (m *Sample) PPid() int32 {
+	return m.PPID
+}
+
+func (m *Sample) Timestamp() time.Time {
+	return m.Time
+}
+
+// Key identifies a series, e.g. gpu_util{device=0}
+func (m *Sample) Key() string {
+	return SeriesKey(m.Name, m.Labels)
+}
+
+func SeriesKey(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	pairs := make([]string, 0, len(labels))
+	for _, label := range slices.Sorted(maps.Keys(labels)) {
+		pairs = append(pairs, label+"="+labels[label])
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// SeriesSummary summarises one series of a job. Value is aggregated as the
+// first sample of the series says, Last, Min and Max are kept for every series.
+type SeriesSummary struct {
+	Name        string
+	Labels      map[string]string `json:",omitempty"`
+	Unit        string
+	Aggregation Aggregation
+	Value       float64
+	Last        float64
+	Min         float64
+	Max         float64
+	Count       int
+	// Weight is the time in seconds covered by the values up to Until
+	Weight float64
+	Until  time.Time
+}
+
+// add folds the value sampled at time at, start is the start of the job
+func (s *SeriesSummary) add(value float64, at, start time.Time) {
+	kind, aggregator := aggregatorOf(s.Aggregation)
+	if s.Count == 0 {
+		s.Min, s.Max = value, value
+	}
+	previous := s.Until
+	if previous.IsZero() {
+		previous = start
+	}
+	var span float64
+	if !previous.IsZero() && at.After(previous) {
+		span = at.Sub(previous).Seconds()
+	}
+	s.Aggregation = kind
+	s.Value = aggregator(s.Value, s.Count, s.Weight, value, span)
+	s.Weight += span
+	s.Until = at
+	s.Last = value
+	s.Min = min(s.Min, value)
+	s.Max = max(s.Max, value)
+	s.Count++
+}
+
+type MetricsSummary struct {
+	Start  time.Time
+	End    time.Time
+	Name   string
+	Series map[string]SeriesSummary
+}
+
+func (m MetricsSummary) JobName() string {
+	return m.Name
+}
+
+func (m MetricsSummary) Span() (time.Time, time.Time) {
+	return m.Start, m.End
+}
+
+// AggregateUniqueSamples summarises every series of a job. Values of processes
+// of the job sampled at the same time are summed first, like the memory of a
+// process tree, and the sums are aggregated.
+func AggregateUniqueSamples(before MetricsSummary, samples []Sample) MetricsSummary {
+	if len(samples) == 0 {
+		return before
+	}
+
+	type sweep struct {
+		key  string
+		time time.Time
+	}
+	totals := make(map[sweep]float64)
+	first := make(map[string]Sample)
+	for _, sample := range samples {
+		key := sample.Key()
+		totals[sweep{key, sample.Time}] += sample.Value
+		if _, ok := first[key]; !ok {
+			first[key] = sample
+		}
+	}
+	sweeps := slices.SortedFunc(maps.Keys(totals), func(a, b sweep) int {
+		if c := a.time.Compare(b.time); c != 0 {
+			return c
+		}
+		return strings.Compare(a.key, b.key)
+	})
+
+	after := before
+	// summaries are shared with snapshots, a new map leaves them untouched
+	after.Series = maps.Clone(before.Series)
+	if after.Series == nil {
+		after.Series = make(map[string]SeriesSummary)
+	}
+	for _, s := range sweeps {
+		series, ok := after.Series[s.key]
+		if !ok {
+			sample := first[s.key]
+			series = SeriesSummary{
+				Name:        sample.Name,
+				Labels:      sample.Labels,
+				Unit:        sample.Unit,
+				Aggregation: sample.Aggregation,
+			}
+		}
+		series.add(totals[s], s.time, after.Start)
+		after.Series[s.key] = series
+		if s.time.After(after.End) {
+			after.End = s.time
+		}
+	}
+	return after
+}
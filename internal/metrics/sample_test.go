@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+var start = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func at(seconds float64) time.Time {
+	return start.Add(time.Duration(seconds * float64(time.Second)))
+}
+
+func TestAggregateSeries(t *testing.T) {
+	cases := []struct {
+		name        string
+		aggregation Aggregation
+		times       []float64
+		values      []float64
+		want        float64
+	}{
+		{"mean of even samples", AggregateMean, []float64{1, 2, 3}, []float64{3, 6, 9}, 6},
+		{"mean weighted by time", AggregateMean, []float64{1, 2, 11}, []float64{10, 10, 1}, 10*2.0/11 + 1*9.0/11},
+		{"default is the mean", "", []float64{4, 5}, []float64{0, 5}, 1},
+		{"max", AggregateMax, []float64{1, 2, 3}, []float64{-4, -1, -2}, -1},
+		{"min", AggregateMin, []float64{1, 2, 3}, []float64{4, 1, 2}, 1},
+		{"sum", AggregateSum, []float64{1, 5, 6}, []float64{1, 2, 3}, 6},
+		{"last", AggregateLast, []float64{1, 2, 3}, []float64{1, 3, 2}, 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			summary := MetricsSummary{Start: start, Name: "job"}
+			for i, value := range c.values {
+				summary = AggregateUniqueSamples(summary, []Sample{{
+					Pid_id:      1,
+					Name:        "load",
+					Aggregation: c.aggregation,
+					Value:       value,
+					Time:        at(c.times[i]),
+				}})
+			}
+			series := summary.Series["load"]
+			if !closeTo(series.Value, c.want) {
+				t.Errorf("value %v, want %v", series.Value, c.want)
+			}
+			if series.Count != len(c.values) || series.Last != c.values[len(c.values)-1] {
+				t.Errorf("count %d and last %v, want %d and %v", series.Count, series.Last, len(c.values), c.values[len(c.values)-1])
+			}
+			if !summary.End.Equal(at(c.times[len(c.times)-1])) {
+				t.Errorf("end %v, want the time of the last sample", summary.End)
+			}
+		})
+	}
+}
+
+func TestAggregateSamplesSumsProcesses(t *testing.T) {
+	samples := []Sample{
+		{Pid_id: 1, Name: "threads", Labels: map[string]string{"device": "0"}, Value: 2, Time: at(1)},
+		{Pid_id: 2, Name: "threads", Labels: map[string]string{"device": "0"}, Value: 3, Time: at(1)},
+		{Pid_id: 1, Name: "threads", Labels: map[string]string{"device": "1"}, Value: 7, Time: at(1)},
+	}
+	summary := AggregateUniqueSamples(MetricsSummary{Start: start}, samples)
+	for key, want := range map[string]float64{"threads{device=0}": 5, "threads{device=1}": 7} {
+		if got := summary.Series[key].Value; got != want {
+			t.Errorf("%s: got %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestAggregateSamplesLeavesBeforeUntouched(t *testing.T) {
+	before := AggregateUniqueSamples(MetricsSummary{Start: start}, []Sample{{Name: "a", Value: 1, Time: at(1)}})
+	AggregateUniqueSamples(before, []Sample{{Name: "a", Value: 5, Time: at(2)}, {Name: "b", Value: 1, Time: at(2)}})
+	if len(before.Series) != 1 || before.Series["a"].Count != 1 {
+		t.Errorf("aggregating changed the earlier summary: %+v", before.Series)
+	}
+}
+
+func TestKindRegistry(t *testing.T) {
+	cases := []struct {
+		sample Metric
+		kind   string
+	}{
+		{&CPUMetric{}, KindCPU},
+		{&GPUMetric{}, KindGPU},
+		{&IOMetric{}, KindIO},
+		{&NetMetric{}, KindNet},
+		{&EnergyMetric{}, KindEnergy},
+		{&Sample{}, KindMetrics},
+	}
+	for _, c := range cases {
+		kind, ok := KindOf(c.sample)
+		if !ok || kind.Name != c.kind {
+			t.Errorf("%T: got kind %v, want %s", c.sample, kind, c.kind)
+			continue
+		}
+		if found, ok := LookupKind(c.kind); !ok || found != kind {
+			t.Errorf("LookupKind(%q) does not find the kind of %T", c.kind, c.sample)
+		}
+		if found, ok := LookupSample(kind.SampleName()); !ok || found != kind {
+			t.Errorf("LookupSample(%q) does not find the kind of %T", kind.SampleName(), c.sample)
+		}
+	}
+	if _, ok := LookupKind("bogus"); ok {
+		t.Error("LookupKind found an unregistered kind")
+	}
+	if kind, _ := LookupKind(KindMetrics); kind.SampleName() != "metric" {
+		t.Errorf("samples of the metrics kind are tagged %q, want metric", kind.SampleName())
+	}
+}
+
+type testSummary struct {
+	Start time.Time
+	Name  string
+	Total float64
+}
+
+func (s testSummary) JobName() string {
+	return s.Name
+}
+
+func (s testSummary) Span() (time.Time, time.Time) {
+	return s.Start, s.Start
+}
+
+type testMetric struct {
+	Value float64
+}
+
+func (m *testMetric) Pid() int32           { return 1 }
+func (m *testMetric) PPid() int32          { return 1 }
+func (m *testMetric) Timestamp() time.Time { return start }
+
+func TestRegisterKind(t *testing.T) {
+	kind := NewKind("test", func(start time.Time, name string) testSummary {
+		return testSummary{Start: start, Name: name}
+	}, func(before testSummary, samples []testMetric) testSummary {
+		for _, sample := range samples {
+			before.Total += sample.Value
+		}
+		return before
+	})
+	RegisterKind(kind)
+	defer func() {
+		kinds.Lock()
+		kinds.ordered = kinds.ordered[:len(kinds.ordered)-1]
+		kinds.Unlock()
+	}()
+
+	names := KindNames()
+	if names[len(names)-1] != "test" {
+		t.Fatalf("kinds %v, want test registered last", names)
+	}
+	found, ok := KindOf(&testMetric{})
+	if !ok || found != kind {
+		t.Fatal("KindOf does not find the registered kind")
+	}
+	summary := kind.Aggregate(kind.NewSummary(start, "job"), []Metric{&testMetric{Value: 2}, &testMetric{Value: 3}})
+	if got := summary.(testSummary); got.Total != 5 || got.Name != "job" {
+		t.Errorf("aggregated %+v, want a total of 5 for job", got)
+	}
+	decoded, err := kind.DecodeSummary([]byte(`{"Name":"job","Total":5}`))
+	if err != nil || decoded.(testSummary).Total != 5 {
+		t.Errorf("decoded %+v, %v", decoded, err)
+	}
+	if sample, ok := kind.NewSample().(*testMetric); !ok || sample == nil {
+		t.Errorf("new sample %T, want *testMetric", kind.NewSample())
+	}
+}
@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+)
+
+// SketchAccuracy is the relative accuracy of quantiles returned by Sketch
+const SketchAccuracy = 0.01
+
+// Sketch is a mergeable quantile sketch (DDSketch) with logarithmic buckets,
+// any quantile is returned within SketchAccuracy relative error. Values
+// below minSketchValue, including negative ones, fall into the zero bucket.
+type Sketch struct {
+	Bins  map[int]uint64 `json:"bins"`
+	Zero  uint64         `json:"zero"`
+	Count uint64         `json:"count"`
+}
+
+const minSketchValue = 1e-9
+
+var sketchGamma = (1 + SketchAccuracy) / (1 - SketchAccuracy)
+var sketchLogGamma = math.Log(sketchGamma)
+
+func (s *Sketch) Add(value float64) {
+	if s.Bins == nil {
+		s.Bins = make(map[int]uint64)
+	}
+	s.Count++
+	if value < minSketchValue {
+		s.Zero++
+		return
+	}
+	s.Bins[int(math.Ceil(math.Log(value)/sketchLogGamma))]++
+}
+
+func (s *Sketch) Merge(other Sketch) {
+	if s.Bins == nil {
+		s.Bins = make(map[int]uint64)
+	}
+	for index, count := range other.Bins {
+		s.Bins[index] += count
+	}
+	s.Zero += other.Zero
+	s.Count += other.Count
+}
+
+func (s Sketch) Quantile(q float64) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(s.Count-1))
+	if rank < s.Zero {
+		return 0
+	}
+	seen := s.Zero
+
+	indexes := make([]int, 0, len(s.Bins))
+	for index := range s.Bins {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		seen += s.Bins[index]
+		if seen > rank {
+			return 2 * math.Pow(sketchGamma, float64(index)) / (sketchGamma + 1)
+		}
+	}
+	return 2 * math.Pow(sketchGamma, float64(indexes[len(indexes)-1])) / (sketchGamma + 1)
+}
+
+// Distribution keeps streaming statistics of a metric: extremes, mean and
+// variance (Welford) and a quantile sketch, all of them mergeable.
+type Distribution struct {
+	Count  uint64
+	Min    float64
+	Max    float64
+	Mean   float64
+	M2     float64
+	Sketch Sketch
+}
+
+func (d *Distribution) Add(value float64) {
+	if d.Count == 0 || value < d.Min {
+		d.Min = value
+	}
+	if d.Count == 0 || value > d.Max {
+		d.Max = value
+	}
+	d.Count++
+	delta := value - d.Mean
+	d.Mean += delta / float64(d.Count)
+	d.M2 += delta * (value - d.Mean)
+	d.Sketch.Add(value)
+}
+
+func (d *Distribution) Merge(other Distribution) {
+	if other.Count == 0 {
+		return
+	}
+	if d.Count == 0 {
+		*d = other.Clone()
+		return
+	}
+	total := d.Count + other.Count
+	delta := other.Mean - d.Mean
+	d.M2 += other.M2 + delta*delta*float64(d.Count)*float64(other.Count)/float64(total)
+	d.Mean += delta * float64(other.Count) / float64(total)
+	d.Count = total
+	d.Min = min(d.Min, other.Min)
+	d.Max = max(d.Max, other.Max)
+	d.Sketch.Merge(other.Sketch)
+}
+
+func (d Distribution) Std() float64 {
+	if d.Count < 2 {
+		return 0
+	}
+	return math.Sqrt(d.M2 / float64(d.Count-1))
+}
+
+func (d Distribution) Quantile(q float64) float64 {
+	if d.Count == 0 {
+		return 0
+	}
+	return min(max(d.Sketch.Quantile(q), d.Min), d.Max)
+}
+
+// Clone returns a deep copy, summaries are values and must not share bins
+func (d Distribution) Clone() Distribution {
+	clone := d
+	clone.Sketch = Sketch{Zero: d.Sketch.Zero, Count: d.Sketch.Count, Bins: make(map[int]uint64, len(d.Sketch.Bins))}
+	for index, count := range d.Sketch.Bins {
+		clone.Sketch.Bins[index] = count
+	}
+	return clone
+}
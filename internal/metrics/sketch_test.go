@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+var quantiles = []float64{0, 0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99, 1}
+
+func sequence(n int, value func(i int, r *rand.Rand) float64) []float64 {
+	r := rand.New(rand.NewPCG(1, 2))
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = value(i, r)
+	}
+	return values
+}
+
+var datasets = []struct {
+	name   string
+	values []float64
+}{
+	{"single", []float64{42}},
+	{"constant", sequence(100, func(int, *rand.Rand) float64 { return 3.5 })},
+	{"linear", sequence(1000, func(i int, _ *rand.Rand) float64 { return float64(i + 1) })},
+	{"uniform", sequence(5000, func(_ int, r *rand.Rand) float64 { return 100 * r.Float64() })},
+	{"exponential", sequence(5000, func(_ int, r *rand.Rand) float64 { return r.ExpFloat64() })},
+	{"wide", sequence(5000, func(_ int, r *rand.Rand) float64 { return math.Pow(10, -3+9*r.Float64()) })},
+	{"zeros", sequence(1000, func(i int, _ *rand.Rand) float64 { return float64(i % 3) })},
+	{"negative", sequence(1000, func(i int, _ *rand.Rand) float64 { return float64(i - 500) })},
+}
+
+// naiveQuantile uses the same rank as Sketch.Quantile on sorted values
+func naiveQuantile(sorted []float64, q float64) float64 {
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+func TestSketchQuantileAccuracy(t *testing.T) {
+	for _, dataset := range datasets {
+		t.Run(dataset.name, func(t *testing.T) {
+			var sketch Sketch
+			for _, value := range dataset.values {
+				sketch.Add(value)
+			}
+			sorted := slices.Sorted(slices.Values(dataset.values))
+			for _, q := range quantiles {
+				expected := naiveQuantile(sorted, q)
+				got := sketch.Quantile(q)
+				if expected < minSketchValue {
+					if got != 0 {
+						t.Errorf("q=%v: got %v, want 0 for %v in the zero bucket", q, got, expected)
+					}
+					continue
+				}
+				if math.Abs(got-expected) > SketchAccuracy*expected*(1+1e-9) {
+					t.Errorf("q=%v: got %v, want %v within %v", q, got, expected, SketchAccuracy)
+				}
+			}
+		})
+	}
+}
+
+func TestSketchEmpty(t *testing.T) {
+	var sketch Sketch
+	if got := sketch.Quantile(0.5); got != 0 {
+		t.Errorf("empty sketch: got %v, want 0", got)
+	}
+	var distribution Distribution
+	if got := distribution.Quantile(0.5); got != 0 {
+		t.Errorf("empty distribution: got %v, want 0", got)
+	}
+	if got := distribution.Std(); got != 0 {
+		t.Errorf("empty distribution std: got %v, want 0", got)
+	}
+}
+
+func TestSketchMerge(t *testing.T) {
+	for _, dataset := range datasets {
+		for _, parts := range []int{1, 2, 3, 7} {
+			var whole, merged Sketch
+			split := make([]Sketch, parts)
+			for i, value := range dataset.values {
+				whole.Add(value)
+				split[i%parts].Add(value)
+			}
+			for _, part := range split {
+				merged.Merge(part)
+			}
+			if !reflect.DeepEqual(whole, merged) {
+				t.Errorf("%s in %d parts: merged sketch differs from the sketch of all values", dataset.name, parts)
+			}
+		}
+	}
+}
+
+func naiveMoments(values []float64) (mean, std float64) {
+	for _, value := range values {
+		mean += value
+	}
+	mean /= float64(len(values))
+	if len(values) < 2 {
+		return mean, 0
+	}
+	var squares float64
+	for _, value := range values {
+		squares += (value - mean) * (value - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(values)-1))
+}
+
+func closeTo(got, expected float64) bool {
+	return math.Abs(got-expected) <= 1e-9*max(1, math.Abs(expected))
+}
+
+func checkDistribution(t *testing.T, label string, distribution Distribution, values []float64) {
+	t.Helper()
+	mean, std := naiveMoments(values)
+	if distribution.Count != uint64(len(values)) {
+		t.Errorf("%s: count %d, want %d", label, distribution.Count, len(values))
+	}
+	if !closeTo(distribution.Mean, mean) {
+		t.Errorf("%s: mean %v, want %v", label, distribution.Mean, mean)
+	}
+	if !closeTo(distribution.Std(), std) {
+		t.Errorf("%s: std %v, want %v", label, distribution.Std(), std)
+	}
+	if distribution.Min != slices.Min(values) || distribution.Max != slices.Max(values) {
+		t.Errorf("%s: range [%v, %v], want [%v, %v]", label, distribution.Min, distribution.Max, slices.Min(values), slices.Max(values))
+	}
+}
+
+func TestDistributionMoments(t *testing.T) {
+	for _, dataset := range datasets {
+		t.Run(dataset.name, func(t *testing.T) {
+			var distribution Distribution
+			for _, value := range dataset.values {
+				distribution.Add(value)
+			}
+			checkDistribution(t, "streamed", distribution, dataset.values)
+
+			for _, parts := range []int{2, 3, 7} {
+				split := make([]Distribution, parts)
+				for i, value := range dataset.values {
+					split[i*parts/len(dataset.values)].Add(value)
+				}
+				var merged Distribution
+				for _, part := range split {
+					merged.Merge(part)
+				}
+				checkDistribution(t, "merged", merged, dataset.values)
+			}
+		})
+	}
+}
+
+func TestDistributionCloneIsDeep(t *testing.T) {
+	var distribution Distribution
+	distribution.Add(1)
+	clone := distribution.Clone()
+	clone.Add(1000)
+	if distribution.Count != 1 || distribution.Sketch.Count != 1 || len(distribution.Sketch.Bins) != 1 {
+		t.Errorf("adding to a clone changed the original: %+v", distribution)
+	}
+}
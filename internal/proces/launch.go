@@ -0,0 +1,243 @@
+package proces
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Spec describes a job to be launched, either by `met run` or by the daemon
+// on behalf of a client.
+type Spec struct {
+	Name    string   `json:"name"`
+	Command string   `json:"command"`
+	WorkDir string   `json:"work_dir,omitempty"`
+	Env     []string `json:"env,omitempty"`
+	Cpus    int      `json:"cpus,omitempty"`
+	Memory  string   `json:"mem,omitempty"`
+}
+
+// ParseMemory follows SLURM --mem syntax, a number with an optional K, M, G
+// or T suffix; megabytes are assumed without a suffix.
+func ParseMemory(value string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	multipliers := map[byte]uint64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30, 'T': 1 << 40}
+	multiplier := uint64(1 << 20)
+	last := strings.ToUpper(value)[len(value)-1]
+	if m, ok := multipliers[last]; ok {
+		multiplier = m
+		value = value[:len(value)-1]
+	}
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil || amount < 0 {
+		return 0, fmt.Errorf("invalid memory request %q", value)
+	}
+	return uint64(amount * float64(multiplier)), nil
+}
+
+// CheckName refuses job names that cannot be used as a file name in the
+// working directory, the log files of the job are named after it
+func CheckName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("missing job name")
+	case strings.ContainsAny(name, "/\x00"):
+		return fmt.Errorf("invalid job name %q, it must not contain / or NUL", name)
+	case strings.HasPrefix(name, "."):
+		return fmt.Errorf("invalid job name %q, it must not start with a dot", name)
+	}
+	return nil
+}
+
+// exitWrapper runs the command in a child shell and records the status it
+// waited for in a file named after its PID, which is the PGID of the job. It
+// is used when the daemon is not the parent of the job and cannot wait for it.
+// Traps of the command do not reach the wrapper, which outlives signals sent
+// to the job to record them.
+const exitWrapper = `trap : HUP INT TERM
+sh -c "$SKALD_COMMAND"
+code=$?
+printf "%d" "$code" > "$SKALD_EXIT_DIR/$$"
+exit "$code"
+`
+
+// accountPath is the PATH of jobs launched as another user, they do not
+// inherit the environment of the daemon
+const accountPath = "/usr/local/bin:/usr/bin:/bin"
+
+// logRedirect makes the job shell open its own log files, so they are created
+// with the permissions of the user the job runs as
+const logRedirect = `exec >"$SKALD_OUT" 2>"$SKALD_ERR"` + "\n"
+
+// Account is a user a job is launched as on behalf of a client
+type Account struct {
+	Name       string
+	Home       string
+	Shell      string
+	Credential *syscall.Credential
+}
+
+// LookupAccount returns the user uid with all of its groups
+func LookupAccount(uid uint32) (*Account, error) {
+	account, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(account.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	groupIDs, err := account.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]uint32, 0, len(groupIDs))
+	for _, id := range groupIDs {
+		if group, err := strconv.ParseUint(id, 10, 32); err == nil {
+			groups = append(groups, uint32(group))
+		}
+	}
+	return &Account{
+		Name:       account.Username,
+		Home:       account.HomeDir,
+		Shell:      loginShell(account.Username),
+		Credential: &syscall.Credential{Uid: uid, Gid: uint32(gid), Groups: groups},
+	}, nil
+}
+
+// loginShell reads the shell of user from /etc/passwd, /bin/sh when it is
+// not listed
+func loginShell(name string) string {
+	content, err := os.ReadFile("/etc/passwd")
+	if err != nil {
+		return "/bin/sh"
+	}
+	for line := range strings.Lines(string(content)) {
+		fields := strings.Split(strings.TrimSpace(line), ":")
+		if len(fields) == 7 && fields[0] == name && fields[6] != "" {
+			return fields[6]
+		}
+	}
+	return "/bin/sh"
+}
+
+// Environ is the environment of a job before the variables of its spec, a job
+// of another user only gets the variables describing that user
+func (a *Account) Environ() []string {
+	if a == nil {
+		return os.Environ()
+	}
+	return []string{
+		"HOME=" + a.Home,
+		"USER=" + a.Name,
+		"LOGNAME=" + a.Name,
+		"SHELL=" + a.Shell,
+		"PATH=" + accountPath,
+	}
+}
+
+// Launch starts the command of spec in a new process group with standard
+// output and error redirected to <name>.out and <name>.err in the working
+// directory. The caller is responsible for waiting on the returned command.
+// With exitDir set a wrapper shell records the exit status of the command in
+// exitDir for a daemon that is not its parent. With account set the job runs
+// as that user with the environment of Account.Environ, in its home directory
+// unless spec names one, and opens the log files itself.
+func Launch(spec Spec, exitDir string, account *Account) (Process, *exec.Cmd, error) {
+	if err := CheckName(spec.Name); err != nil {
+		return Process{}, nil, err
+	}
+	if strings.TrimSpace(spec.Command) == "" {
+		return Process{}, nil, errors.New("missing command")
+	}
+	memory, err := ParseMemory(spec.Memory)
+	if err != nil {
+		return Process{}, nil, err
+	}
+	workDir := spec.WorkDir
+	if workDir == "" && account != nil {
+		workDir = account.Home
+	}
+	if workDir == "" {
+		if workDir, err = os.Getwd(); err != nil {
+			return Process{}, nil, err
+		}
+	}
+	workDir, err = filepath.Abs(workDir)
+	if err != nil {
+		return Process{}, nil, err
+	}
+	logPath := filepath.Join(workDir, spec.Name+".out")
+	errPath := filepath.Join(workDir, spec.Name+".err")
+
+	env := append(account.Environ(), spec.Env...)
+	script := spec.Command
+	if exitDir != "" {
+		script = exitWrapper
+		env = append(env, "SKALD_EXIT_DIR="+exitDir, "SKALD_COMMAND="+spec.Command)
+	}
+	cmd := exec.Command("sh", "-c", script)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Dir = workDir
+	if account != nil {
+		cmd.SysProcAttr.Credential = account.Credential
+		cmd.Args[2] = logRedirect + script
+		cmd.Env = append(env, "SKALD_OUT="+logPath, "SKALD_ERR="+errPath)
+	} else {
+		fileOut, err := os.Create(logPath)
+		if err != nil {
+			return Process{}, nil, fmt.Errorf("failed to create log file: %w", err)
+		}
+		defer fileOut.Close()
+		fileErr, err := os.Create(errPath)
+		if err != nil {
+			return Process{}, nil, fmt.Errorf("failed to create log file: %w", err)
+		}
+		defer fileErr.Close()
+		cmd.Env = env
+		cmd.Stdout = fileOut
+		cmd.Stderr = fileErr
+	}
+	if err := cmd.Start(); err != nil {
+		return Process{}, nil, fmt.Errorf("failed to execute command: %w", err)
+	}
+	exitFile := ""
+	if exitDir != "" {
+		exitFile = filepath.Join(exitDir, strconv.Itoa(cmd.Process.Pid))
+	}
+
+	return Process{
+		PGID:      int32(cmd.Process.Pid),
+		Command:   spec.Command,
+		StartTime: time.Now(),
+		Name:      spec.Name,
+		LogPath:   logPath,
+		ErrPath:   errPath,
+		ExitFile:  exitFile,
+		WorkDir:   workDir,
+		EnvDigest: EnvDigest(env),
+		Cpus:      spec.Cpus,
+		Memory:    memory,
+	}, cmd, nil
+}
+
+// ExitStatus follows the convention of shells, a command killed by a signal
+// exits with 128 plus the signal number
+func ExitStatus(state *os.ProcessState) (int, bool) {
+	if state == nil {
+		return 0, false
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal()), true
+	}
+	return state.ExitCode(), true
+}
@@ -1,15 +1,109 @@
 package proces
 
-import "time"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
 
-type Request struct {
-	Type string `json:"type"`
-}
+const (
+	StateRunning  = "running"
+	StateOrphaned = "orphaned"
+	StateFinished = "finished"
+)
 
 type Process struct {
+	ID        uint64    `json:"id,omitempty"`
 	PGID      int32     `json:"pid"`
 	Name      string    `json:"name"`
 	Command   string    `json:"command"`
 	LogPath   string    `json:"log_path"`
+	ErrPath   string    `json:"err_path,omitempty"`
+	ExitFile  string    `json:"exit_file,omitempty"`
+	WorkDir   string    `json:"work_dir,omitempty"`
+	EnvDigest string    `json:"env_digest,omitempty"`
 	StartTime time.Time `json:"start_time"`
+	Cpus      int       `json:"cpus,omitempty"`
+	Memory    uint64    `json:"memory,omitempty"`
+	UID       uint32    `json:"uid"`
+	User      string    `json:"user,omitempty"`
+
+	State    string    `json:"state,omitempty"`
+	EndTime  time.Time `json:"end_time,omitzero"`
+	ExitCode *int      `json:"exit_code,omitempty"`
+}
+
+// JobEvent reports a change of the job state detected by the daemon
+type JobEvent struct {
+	PGID  int32     `json:"pid"`
+	State string    `json:"state"`
+	Time  time.Time `json:"time"`
+	// ExitCode is known for finished jobs the daemon launched itself
+	ExitCode *int `json:"exit_code,omitempty"`
+}
+
+// Validate checks that a submitted job carries everything the daemon needs,
+// checks against the state of the machine are left to the daemon.
+func (p *Process) Validate() error {
+	switch {
+	case p.PGID <= 0:
+		return fmt.Errorf("invalid PGID %d", p.PGID)
+	case p.Name == "":
+		return errors.New("missing job name")
+	case strings.TrimSpace(p.Command) == "":
+		return errors.New("missing command")
+	case p.StartTime.IsZero():
+		return errors.New("missing start time")
+	case p.Cpus < 0:
+		return fmt.Errorf("invalid number of cpus %d", p.Cpus)
+	}
+	return nil
+}
+
+// EnvDigest summarises an environment independently of variable order, so
+// two jobs can be compared without storing the environment itself.
+func EnvDigest(environ []string) string {
+	sorted := slices.Clone(environ)
+	slices.Sort(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ReadExitCode reads the status written by the job wrapper on exit and
+// removes the file. Only a regular file owned by the user of the job is
+// trusted, the daemon may run as root and the path is known to every user.
+func (p *Process) ReadExitCode() (int, bool) {
+	if p.ExitFile == "" {
+		return 0, false
+	}
+	file, err := os.OpenFile(p.ExitFile, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return 0, false
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || stat.Uid != p.UID {
+		return 0, false
+	}
+	defer os.Remove(p.ExitFile)
+	content, err := io.ReadAll(io.LimitReader(file, 32))
+	if err != nil {
+		return 0, false
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, false
+	}
+	return code, true
 }
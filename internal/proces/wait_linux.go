@@ -0,0 +1,19 @@
+package proces
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// WaitExited blocks until the process exits without reaping it, the process
+// and its group stay reserved until it is waited for.
+func WaitExited(pid int) error {
+	var info unix.Siginfo
+	for {
+		err := unix.Waitid(unix.P_PID, pid, &info, unix.WEXITED|unix.WNOWAIT, nil)
+		if !errors.Is(err, unix.EINTR) {
+			return err
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package proces
+
+// WaitExited returns at once where processes cannot be waited for without
+// reaping them
+func WaitExited(pid int) error {
+	return nil
+}
@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/Wesenheit/Skaldenmet/internal/proces"
+)
+
+// Version of the wire protocol, bumped on every incompatible change
+const Version = 1
+
+// Request types, any other type names a kind of metrics registered with
+// metrics.RegisterKind and asks for its summaries
+const (
+	TypeRegister  = "register"
+	TypeJobs      = "jobs"
+	TypeProcesses = "processes"
+	TypeSeries    = "series"
+	TypeSubscribe = "subscribe"
+	TypeCancel    = "cancel"
+	TypeReload    = "reload"
+	TypeExitDir   = "exitdir"
+)
+
+// Hello opens every connection, the daemon answers with its own version and
+// refuses clients speaking a different one.
+type Hello struct {
+	Version int    `json:"version"`
+	Client  string `json:"client,omitempty"`
+}
+
+type Request struct {
+	ID      uint64          `json:"id"`
+	Type    string          `json:"type"`
+	Job     int32           `json:"job,omitempty"`
+	Events  []string        `json:"events,omitempty"`
+	Process *proces.Process `json:"process,omitempty"`
+	// Cluster asks the daemon to forward the request to its peers, the
+	// answer is a list of NodeResult, one per node
+	Cluster bool `json:"cluster,omitempty"`
+}
+
+// NodeResult is the answer of one node of the cluster, a node that could
+// not be queried carries an error instead of data.
+type NodeResult struct {
+	Node  string          `json:"node"`
+	Error string          `json:"error,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// Response is the envelope of every reply, Data is only set when OK is true
+// and ID repeats the ID of the request it answers.
+type Response struct {
+	ID    uint64          `json:"id"`
+	OK    bool            `json:"ok"`
+	Error string          `json:"error,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+func Success(id uint64, data any) (Response, error) {
+	if data == nil {
+		return Response{ID: id, OK: true}, nil
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Response{}, err
+	}
+	return Response{ID: id, OK: true, Data: raw}, nil
+}
+
+func Failure(id uint64, format string, args ...any) Response {
+	return Response{ID: id, Error: fmt.Sprintf(format, args...)}
+}
+
+// Conn exchanges newline delimited JSON messages over a stream
+type Conn struct {
+	encoder *json.Encoder
+	decoder *json.Decoder
+}
+
+func NewConn(rw io.ReadWriter) *Conn {
+	return &Conn{encoder: json.NewEncoder(rw), decoder: json.NewDecoder(rw)}
+}
+
+func (c *Conn) Send(message any) error {
+	return c.encoder.Encode(message)
+}
+
+func (c *Conn) Receive(message any) error {
+	return c.decoder.Decode(message)
+}
+
+// Accept performs the daemon side of the handshake
+func (c *Conn) Accept() error {
+	var hello Hello
+	if err := c.Receive(&hello); err != nil {
+		return fmt.Errorf("failed to read handshake: %w", err)
+	}
+	if hello.Version != Version {
+		c.Send(Failure(0, "unsupported protocol version %d, daemon speaks %d", hello.Version, Version))
+		return fmt.Errorf("client %q speaks unsupported protocol version %d", hello.Client, hello.Version)
+	}
+	response, err := Success(0, Hello{Version: Version, Client: "met daemon"})
+	if err != nil {
+		return err
+	}
+	return c.Send(response)
+}
@@ -0,0 +1,171 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Wesenheit/Skaldenmet/internal/proces"
+)
+
+func TestHandshake(t *testing.T) {
+	cases := []struct {
+		name    string
+		version int
+		ok      bool
+		error   string
+	}{
+		{"current version", Version, true, ""},
+		{"older version", Version - 1, false, "unsupported protocol version"},
+		{"newer version", Version + 1, false, "unsupported protocol version"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client, daemon := net.Pipe()
+			defer client.Close()
+			accepted := make(chan error, 1)
+			go func() {
+				defer daemon.Close()
+				accepted <- NewConn(daemon).Accept()
+			}()
+
+			conn := NewConn(client)
+			if err := conn.Send(Hello{Version: c.version, Client: "test"}); err != nil {
+				t.Fatal(err)
+			}
+			var response Response
+			if err := conn.Receive(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.OK != c.ok || response.ID != 0 {
+				t.Fatalf("response %+v, want ok %v", response, c.ok)
+			}
+			if err := <-accepted; (err == nil) != c.ok {
+				t.Errorf("Accept returned %v, want success %v", err, c.ok)
+			}
+			if !c.ok {
+				if !strings.Contains(response.Error, c.error) {
+					t.Errorf("error %q does not mention %q", response.Error, c.error)
+				}
+				return
+			}
+			var hello Hello
+			if err := json.Unmarshal(response.Data, &hello); err != nil {
+				t.Fatal(err)
+			}
+			if hello.Version != Version || hello.Client == "" {
+				t.Errorf("daemon introduced itself as %+v", hello)
+			}
+		})
+	}
+}
+
+func TestHandshakeMalformed(t *testing.T) {
+	client, daemon := net.Pipe()
+	go func() {
+		client.Write([]byte("not json\n"))
+		client.Close()
+	}()
+	if err := NewConn(daemon).Accept(); err == nil {
+		t.Error("a malformed handshake was accepted")
+	}
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	exitCode := 3
+	cases := []struct {
+		name string
+		data any
+		into func() any
+	}{
+		{"no data", nil, nil},
+		{"string", "/run/skaldenmet/exits/1000", func() any { return new(string) }},
+		{"lines", []string{"cpuCollector: restarted"}, func() any { return new([]string) }},
+		{"jobs", map[int32]proces.Process{42: {PGID: 42, Name: "train", ExitCode: &exitCode}}, func() any { return new(map[int32]proces.Process) }},
+		{"node results", []NodeResult{{Node: "ws1", Data: json.RawMessage(`{"1":{}}`)}, {Node: "ws2", Error: "timeout"}}, func() any { return new([]NodeResult) }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			response, err := Success(7, c.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buffer bytes.Buffer
+			if err := NewConn(&buffer).Send(response); err != nil {
+				t.Fatal(err)
+			}
+			if line := buffer.String(); strings.Count(line, "\n") != 1 || !strings.HasSuffix(line, "\n") {
+				t.Fatalf("message %q is not a single line", line)
+			}
+			var received Response
+			if err := NewConn(&buffer).Receive(&received); err != nil {
+				t.Fatal(err)
+			}
+			if received.ID != 7 || !received.OK || received.Error != "" {
+				t.Fatalf("received %+v", received)
+			}
+			if c.data == nil {
+				if received.Data != nil {
+					t.Errorf("data %s, want none", received.Data)
+				}
+				return
+			}
+			decoded := c.into()
+			if err := json.Unmarshal(received.Data, decoded); err != nil {
+				t.Fatal(err)
+			}
+			if got := reflect.ValueOf(decoded).Elem().Interface(); !reflect.DeepEqual(got, c.data) {
+				t.Errorf("round trip gave %+v, want %+v", got, c.data)
+			}
+		})
+	}
+}
+
+func TestFailure(t *testing.T) {
+	response := Failure(9, "job %d not found", 42)
+	data, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"id":9,"ok":false,"error":"job 42 not found"}` {
+		t.Errorf("failure encoded as %s", data)
+	}
+	if _, err := Success(1, func() {}); err == nil {
+		t.Error("data that cannot be encoded was accepted")
+	}
+}
+
+func TestRequestRoundTrip(t *testing.T) {
+	cases := []struct {
+		name    string
+		request Request
+		encoded string
+	}{
+		{"summary", Request{ID: 1, Type: "cpu"}, `{"id":1,"type":"cpu"}`},
+		{"cluster", Request{ID: 2, Type: TypeJobs, Cluster: true}, `{"id":2,"type":"jobs","cluster":true}`},
+		{"job", Request{ID: 3, Type: TypeCancel, Job: 42}, `{"id":3,"type":"cancel","job":42}`},
+		{"subscription", Request{ID: 4, Type: TypeSubscribe, Events: []string{"job", "alert"}}, `{"id":4,"type":"subscribe","events":["job","alert"]}`},
+		{"registration", Request{ID: 5, Type: TypeRegister, Process: &proces.Process{PGID: 42, Name: "train"}}, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := json.Marshal(c.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.encoded != "" && string(data) != c.encoded {
+				t.Errorf("encoded as %s, want %s", data, c.encoded)
+			}
+			var decoded Request
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded, c.request) {
+				t.Errorf("round trip gave %+v, want %+v", decoded, c.request)
+			}
+		})
+	}
+}
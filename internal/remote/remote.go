@@ -0,0 +1,125 @@
+package remote
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultPort of the TLS listener, used when an address has no port
+	DefaultPort = "7420"
+
+	// EnvHost selects a remote daemon for all clients, like the --host flag
+	EnvHost = "SKALD_HOST"
+	EnvCert = "SKALD_TLS_CERT"
+	EnvKey  = "SKALD_TLS_KEY"
+	EnvCA   = "SKALD_TLS_CA"
+)
+
+// Files are the certificate and key presented to the other side and the CA
+// its certificate has to be signed by. Both ends of a connection are
+// authenticated.
+type Files struct {
+	Cert string
+	Key  string
+	CA   string
+}
+
+// Set by the --host and --tls-* flags shared by all subcommands
+var (
+	Host   string
+	Client Files
+)
+
+func orEnv(value, name string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+// Target is the remote daemon chosen by the flag or the environment, empty
+// when clients should use the local socket.
+func Target() string {
+	return orEnv(Host, EnvHost)
+}
+
+// Address appends the default port to an address without one
+func Address(address string) string {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return net.JoinHostPort(address, DefaultPort)
+	}
+	return address
+}
+
+// ClientFiles resolves the files used by clients connecting to Target
+func ClientFiles() Files {
+	return Files{
+		Cert: orEnv(Client.Cert, EnvCert),
+		Key:  orEnv(Client.Key, EnvKey),
+		CA:   orEnv(Client.CA, EnvCA),
+	}
+}
+
+// DaemonFiles reads tls.cert, tls.key and tls.ca from the config, the daemon
+// uses them both for its listener and when querying peers.
+func DaemonFiles(v *viper.Viper) Files {
+	return Files{
+		Cert: v.GetString("tls.cert"),
+		Key:  v.GetString("tls.key"),
+		CA:   v.GetString("tls.ca"),
+	}
+}
+
+func (f Files) load() (tls.Certificate, *x509.CertPool, error) {
+	if f.Cert == "" || f.Key == "" || f.CA == "" {
+		return tls.Certificate{}, nil, errors.New("a certificate, its key and a CA are required for TLS")
+	}
+	cert, err := tls.LoadX509KeyPair(f.Cert, f.Key)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	pem, err := os.ReadFile(f.CA)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("failed to read CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return tls.Certificate{}, nil, fmt.Errorf("no certificates found in %s", f.CA)
+	}
+	return cert, pool, nil
+}
+
+// ServerConfig accepts only clients with a certificate signed by the CA
+func (f Files) ServerConfig() (*tls.Config, error) {
+	cert, pool, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ClientConfig trusts only daemons with a certificate signed by the CA, the
+// certificate has to be valid for the host name or address that is dialed.
+func (f Files) ClientConfig() (*tls.Config, error) {
+	cert, pool, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
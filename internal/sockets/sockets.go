@@ -0,0 +1,179 @@
+package sockets
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// ControlName is the socket serving registrations and queries
+	ControlName = "skald.socket"
+	// ExitsName is the directory of exit files within the socket directory
+	ExitsName = "exits"
+
+	// SystemDir holds the sockets of a daemon running as root
+	SystemDir = "/run/skaldenmet"
+	// EnvDir overrides the socket directory for the daemon and all clients
+	EnvDir = "SKALD_SOCKET_DIR"
+)
+
+// Dir is set by the --socket-dir flag shared by all subcommands
+var Dir string
+
+// UserDir holds the sockets of a daemon running as a regular user
+func UserDir() string {
+	if runtime := os.Getenv("XDG_RUNTIME_DIR"); runtime != "" {
+		return filepath.Join(runtime, "skaldenmet")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("skaldenmet-%d", os.Getuid()))
+}
+
+func explicitDir() string {
+	if Dir != "" {
+		return Dir
+	}
+	return os.Getenv(EnvDir)
+}
+
+// DaemonDir resolves where the daemon creates its sockets: the flag, the
+// environment, sockets.dir in the config, and finally a default depending on
+// whether the daemon runs as root.
+func DaemonDir(v *viper.Viper) string {
+	if dir := explicitDir(); dir != "" {
+		return dir
+	}
+	if dir := v.GetString("sockets.dir"); dir != "" {
+		return dir
+	}
+	if os.Getuid() == 0 {
+		return SystemDir
+	}
+	return UserDir()
+}
+
+// ClientDir resolves where clients look for the daemon. Without an explicit
+// directory the daemon of the user is preferred over the system daemon.
+func ClientDir() string {
+	if dir := explicitDir(); dir != "" {
+		return dir
+	}
+	for _, dir := range []string{UserDir(), SystemDir} {
+		if _, err := os.Stat(filepath.Join(dir, ControlName)); err == nil {
+			return dir
+		}
+	}
+	return UserDir()
+}
+
+func Control(dir string) string {
+	return filepath.Join(dir, ControlName)
+}
+
+// Exits is the directory where jobs launched by `met run` leave their exit
+// status, the daemon does not read exit files anywhere else
+func Exits(dir string) string {
+	return filepath.Join(dir, ExitsName)
+}
+
+// UserExits is the directory of exit files of the user uid within exits
+func UserExits(exits string, uid uint32) string {
+	return filepath.Join(exits, strconv.FormatUint(uint64(uid), 10))
+}
+
+// PrepareUserExits creates the exit directory of the user uid, owned by the
+// user and private to it. Only the daemon creates directories in exits, so
+// no other user can plant exit files there.
+func PrepareUserExits(exits string, uid, gid uint32) (string, error) {
+	dir := UserExits(exits, uid)
+	err := os.Mkdir(dir, 0700)
+	if err == nil {
+		if uid != uint32(os.Getuid()) {
+			if err := os.Chown(dir, int(uid), int(gid)); err != nil {
+				os.Remove(dir)
+				return "", fmt.Errorf("failed to create exit directory: %w", err)
+			}
+		}
+		return dir, nil
+	}
+	if !errors.Is(err, fs.ErrExist) {
+		return "", fmt.Errorf("failed to create exit directory: %w", err)
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || stat.Uid != uid || info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("exit directory %s is not a private directory of user %d", dir, uid)
+	}
+	return dir, nil
+}
+
+// ExitFile is the exit file of the job with process group pgid
+func ExitFile(dir string, pgid int32) string {
+	return filepath.Join(dir, strconv.Itoa(int(pgid)))
+}
+
+// Mode returns permissions of the control socket, sockets.mode in the config as an
+// octal string. Clients are authenticated by their credentials, a system
+// daemon may open its sockets to everyone with "0666".
+func Mode(v *viper.Viper) (os.FileMode, error) {
+	value := v.GetString("sockets.mode")
+	if value == "" {
+		return 0660, nil
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid sockets.mode %q", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// Prepare creates the socket directory of the daemon, readable by everyone
+// for the system daemon running as root and private otherwise.
+func Prepare(dir string) error {
+	perm := os.FileMode(0700)
+	if os.Getuid() == 0 {
+		perm = 0755
+	}
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	// a directory in /tmp could have been created by someone else beforehand
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if ok && stat.Uid != uint32(os.Getuid()) && stat.Uid != 0 {
+		return fmt.Errorf("socket directory %s is owned by another user", dir)
+	}
+	// MkdirAll keeps the mode of an existing directory
+	if ok && stat.Uid == uint32(os.Getuid()) && info.Mode().Perm() != perm {
+		if err := os.Chmod(dir, perm); err != nil {
+			return err
+		}
+	}
+	return prepareExits(Exits(dir))
+}
+
+// prepareExits creates the exit file directory. The system daemon lets users
+// into their own directories of PrepareUserExits, nobody else writes to it.
+func prepareExits(dir string) error {
+	perm := os.FileMode(0700)
+	if os.Getuid() == 0 {
+		perm = 0711
+	}
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return fmt.Errorf("failed to create exit directory: %w", err)
+	}
+	// the umask applies to MkdirAll and the directory may be left over
+	return os.Chmod(dir, perm)
+}
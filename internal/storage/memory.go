@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"log"
+	"maps"
 	"github.com/Wesenheit/Skaldenmet/internal/metrics"
 	"github.com/Wesenheit/Skaldenmet/internal/proces"
 	"sync"
@@ -13,11 +14,16 @@ import (
 )
 
 type MemoryStorage struct {
-	storage_CPU map[int32]metrics.CPUSummaryMetric
-	storage_GPU map[int32]metrics.GPUSummaryMetric
-	mu          sync.RWMutex
-	interval    time.Duration
-	maxSize     uint32
+	jobs map[int32]proces.Process
+	// summaries of every registered kind by kind name and job
+	summaries    map[string]map[int32]metrics.Summary
+	storage_Proc map[int32]map[int32]metrics.ProcessSummary
+	series       map[int32]*Series
+	mu           sync.RWMutex
+	interval     time.Duration
+	maxSize      uint32
+	// retime passes a new aggregation interval to Store
+	retime chan time.Duration
 }
 
 func NewMemoryStorage(v *viper.Viper) (*MemoryStorage, error) {
@@ -33,73 +39,164 @@ func NewMemoryStorage(v *viper.Viper) (*MemoryStorage, error) {
 	}
 
 	return &MemoryStorage{
-		storage_CPU: make(map[int32]metrics.CPUSummaryMetric),
-		storage_GPU: make(map[int32]metrics.GPUSummaryMetric),
-		maxSize:     uint32(maxSize),
-		interval:    duration,
+		jobs:         make(map[int32]proces.Process),
+		summaries:    make(map[string]map[int32]metrics.Summary),
+		storage_Proc: make(map[int32]map[int32]metrics.ProcessSummary),
+		series:       make(map[int32]*Series),
+		maxSize:      uint32(maxSize),
+		interval:     duration,
+		retime:       make(chan time.Duration, 1),
 	}, nil
 }
-func (m *MemoryStorage) Store(ctx context.Context, procChan chan proces.Process, metChan chan []metrics.Metric) error {
-	ticker := time.NewTicker(m.interval)
+
+// Store consumes jobs, job events and samples. It returns once eventChan and
+// metChan are closed, everything sent before is aggregated, cancelling ctx
+// alone does not stop it.
+func (m *MemoryStorage) Store(ctx context.Context, procChan chan proces.Process, eventChan chan proces.JobEvent, metChan chan []metrics.Metric) error {
+	ticker := time.NewTicker(m.Interval())
 	defer ticker.Stop()
 
 	var pendingMetrics []metrics.Metric
 
-	for {
+	done := ctx.Done()
+	for metChan != nil || eventChan != nil {
 		select {
-		case <-ctx.Done():
+		case <-done:
+			// keep draining, samples flushed by stopping collectors arrive
+			// until the channels are closed
 			log.Print("Finalizing Storage")
-			m.AggregateBatch(pendingMetrics)
-			return m.Close()
+			done = nil
 
 		case <-ticker.C:
 			m.AggregateBatch(pendingMetrics)
 			pendingMetrics = nil
 
+		case interval := <-m.retime:
+			ticker.Reset(interval)
+
 		case proc := <-procChan:
 			m.mu.Lock()
-			m.storage_CPU[proc.PGID] = metrics.CPUSummaryMetric{
-				Start: proc.StartTime,
-				Name:  proc.Name,
-			}
-			m.storage_GPU[proc.PGID] = metrics.GPUSummaryMetric{
-				Start: proc.StartTime,
-				Name:  proc.Name,
+			proc.State = proces.StateRunning
+			m.jobs[proc.PGID] = proc
+			for _, kind := range metrics.Kinds() {
+				m.kind(kind.Name)[proc.PGID] = kind.NewSummary(proc.StartTime, proc.Name)
 			}
+			m.storage_Proc[proc.PGID] = make(map[int32]metrics.ProcessSummary)
 			m.mu.Unlock()
 
-		case batch := <-metChan:
+		case event, ok := <-eventChan:
+			if !ok {
+				eventChan = nil
+				continue
+			}
+			m.UpdateJob(event)
+
+		case batch, ok := <-metChan:
+			if !ok {
+				metChan = nil
+				continue
+			}
 			pendingMetrics = append(pendingMetrics, batch...)
 		}
 	}
+	m.AggregateBatch(pendingMetrics)
+	return m.Close()
 }
+func (m *MemoryStorage) UpdateJob(event proces.JobEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[event.PGID]
+	if !ok {
+		return
+	}
+	job.State = event.State
+	if event.State == proces.StateFinished {
+		job.EndTime = event.Time
+		if event.ExitCode != nil {
+			job.ExitCode = event.ExitCode
+		} else if code, ok := job.ReadExitCode(); ok {
+			job.ExitCode = &code
+		}
+	}
+	m.jobs[event.PGID] = job
+}
+
 func (m *MemoryStorage) Close() error {
 	return nil
 }
 
-func AggregateAny[S any, T metrics.Metric, V any](
-	metList []metrics.Metric,
-	storage map[int32]S,
-	aggregator func(S, []V) S,
-	convert func(T) V,
-) {
-	toAggregate := make(map[int32][]V)
+func GroupByJob[T metrics.Metric, V any](metList []metrics.Metric, convert func(T) V) map[int32][]V {
+	grouped := make(map[int32][]V)
 	for _, met := range metList {
 		if specific, ok := met.(T); ok {
 			ppid := specific.PPid()
-			toAggregate[ppid] = append(toAggregate[ppid], convert(specific))
+			grouped[ppid] = append(grouped[ppid], convert(specific))
 		}
 	}
-	for ppid, list := range toAggregate {
-		storage[ppid] = aggregator(storage[ppid], list)
+	return grouped
+}
+
+// kind returns the summaries of a kind, kinds are created on first use
+func (m *MemoryStorage) kind(name string) map[int32]metrics.Summary {
+	summaries, ok := m.summaries[name]
+	if !ok {
+		summaries = make(map[int32]metrics.Summary)
+		m.summaries[name] = summaries
+	}
+	return summaries
+}
+
+// aggregateKinds folds the samples of every kind into the summaries of
+// their jobs, samples of unregistered kinds are only kept in the series
+func (m *MemoryStorage) aggregateKinds(metList []metrics.Metric) {
+	byKind := make(map[*metrics.Kind]map[int32][]metrics.Metric)
+	for _, metric := range metList {
+		kind, ok := metrics.KindOf(metric)
+		if !ok {
+			continue
+		}
+		if byKind[kind] == nil {
+			byKind[kind] = make(map[int32][]metrics.Metric)
+		}
+		byKind[kind][metric.PPid()] = append(byKind[kind][metric.PPid()], metric)
+	}
+	for kind, byJob := range byKind {
+		summaries := m.kind(kind.Name)
+		for ppid, list := range byJob {
+			summaries[ppid] = kind.Aggregate(summaries[ppid], list)
+		}
+	}
+}
+
+func (m *MemoryStorage) aggregateProcesses(metList []metrics.Metric) {
+	cpuByJob := GroupByJob(metList, func(ptr *metrics.CPUMetric) metrics.CPUMetric { return *ptr })
+	gpuByJob := GroupByJob(metList, func(ptr *metrics.GPUMetric) metrics.GPUMetric { return *ptr })
+	for ppid, before := range m.storage_Proc {
+		if len(cpuByJob[ppid]) == 0 && len(gpuByJob[ppid]) == 0 {
+			continue
+		}
+		m.storage_Proc[ppid] = metrics.AggregateProcesses(m.jobs[ppid].StartTime, before, cpuByJob[ppid], gpuByJob[ppid])
 	}
 }
 
 func (m *MemoryStorage) AggregateBatch(metList []metrics.Metric) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	AggregateAny(metList, m.storage_CPU, metrics.AggregateUniqueCPU, func(ptr *metrics.CPUMetric) metrics.CPUMetric { return *ptr })
-	AggregateAny(metList, m.storage_GPU, metrics.AggregateUniqueGPU, func(ptr *metrics.GPUMetric) metrics.GPUMetric { return *ptr })
+	m.aggregateKinds(metList)
+	m.aggregateProcesses(metList)
+	m.appendSeries(metList)
+}
+
+// appendSeries keeps the last maxSize raw samples of every job
+func (m *MemoryStorage) appendSeries(metList []metrics.Metric) {
+	for _, metric := range metList {
+		series, ok := m.series[metric.PPid()]
+		if !ok {
+			series = NewSeries(int(m.maxSize))
+			m.series[metric.PPid()] = series
+		}
+		series.Add(metric)
+	}
 }
 
 func GetSnapshot[T any](storage map[int32]T, mu *sync.RWMutex) map[int32]T {
@@ -112,14 +209,54 @@ func GetSnapshot[T any](storage map[int32]T, mu *sync.RWMutex) map[int32]T {
 	return snapshot
 }
 
-func (m *MemoryStorage) GetCPUSnapshot() map[int32]metrics.CPUSummaryMetric {
-	return GetSnapshot(m.storage_CPU, &m.mu)
+func (m *MemoryStorage) GetJobsSnapshot() map[int32]proces.Process {
+	return GetSnapshot(m.jobs, &m.mu)
 }
 
-func (m *MemoryStorage) GetGPUSnapshot() map[int32]metrics.GPUSummaryMetric {
-	return GetSnapshot(m.storage_GPU, &m.mu)
+// GetSummaries returns the summaries of a kind by job, ok is false when no
+// such kind is registered
+func (m *MemoryStorage) GetSummaries(kind string) (map[int32]metrics.Summary, bool) {
+	if _, ok := metrics.LookupKind(kind); !ok {
+		return nil, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	snapshot := make(map[int32]metrics.Summary, len(m.summaries[kind]))
+	maps.Copy(snapshot, m.summaries[kind])
+	return snapshot, true
+}
+
+func (m *MemoryStorage) GetProcessSnapshot(job int32) map[int32]metrics.ProcessSummary {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return maps.Clone(m.storage_Proc[job])
+}
+
+func (m *MemoryStorage) GetSeries(job int32) []metrics.Metric {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	series, ok := m.series[job]
+	if !ok {
+		return []metrics.Metric{}
+	}
+	return series.Samples()
 }
 
 func (m *MemoryStorage) Interval() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	return m.interval
 }
+
+// SetInterval changes how often pending samples are aggregated, a running
+// Store picks up the new interval with its next iteration.
+func (m *MemoryStorage) SetInterval(interval time.Duration) {
+	m.mu.Lock()
+	m.interval = interval
+	m.mu.Unlock()
+	select {
+	case <-m.retime:
+	default:
+	}
+	m.retime <- interval
+}
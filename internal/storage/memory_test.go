@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Wesenheit/Skaldenmet/internal/metrics"
+	"github.com/Wesenheit/Skaldenmet/internal/proces"
+
+	"github.com/spf13/viper"
+)
+
+var start = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newStorage(t *testing.T, size int) *MemoryStorage {
+	t.Helper()
+	v := viper.New()
+	v.Set("storage.size", size)
+	v.Set("storage.interval", time.Hour)
+	m, err := NewMemoryStorage(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func ioSample(job int32, read uint64, seconds int) *metrics.IOMetric {
+	return &metrics.IOMetric{Pid_id: job + 1, PPID: job, ReadBytes: read, ReadRate: float64(read), Time: start.Add(time.Duration(seconds) * time.Second)}
+}
+
+// unknownMetric is a sample of no registered kind
+type unknownMetric struct{ job int32 }
+
+func (m *unknownMetric) Pid() int32           { return m.job }
+func (m *unknownMetric) PPid() int32          { return m.job }
+func (m *unknownMetric) Timestamp() time.Time { return start }
+
+func TestNewMemoryStorage(t *testing.T) {
+	cases := []struct {
+		name     string
+		size     int
+		interval time.Duration
+		ok       bool
+	}{
+		{"valid", 10, time.Second, true},
+		{"no size", 0, time.Second, false},
+		{"negative size", -1, time.Second, false},
+		{"no interval", 10, 0, false},
+	}
+	for _, c := range cases {
+		v := viper.New()
+		v.Set("storage.size", c.size)
+		v.Set("storage.interval", c.interval)
+		if _, err := NewMemoryStorage(v); (err == nil) != c.ok {
+			t.Errorf("%s: got %v, want success %v", c.name, err, c.ok)
+		}
+	}
+}
+
+func TestAggregateBatch(t *testing.T) {
+	cases := []struct {
+		name    string
+		batches [][]metrics.Metric
+		read    map[int32]uint64
+		peak    map[int32]float64
+		series  map[int32]int
+	}{
+		{
+			"batches of one job add up",
+			[][]metrics.Metric{{ioSample(10, 100, 1)}, {ioSample(10, 50, 2)}},
+			map[int32]uint64{10: 150},
+			map[int32]float64{10: 100},
+			map[int32]int{10: 2},
+		},
+		{
+			"processes of a job sampled together",
+			[][]metrics.Metric{{ioSample(10, 100, 1), &metrics.IOMetric{Pid_id: 12, PPID: 10, ReadBytes: 20, ReadRate: 20, Time: start.Add(time.Second)}}},
+			map[int32]uint64{10: 120},
+			map[int32]float64{10: 120},
+			map[int32]int{10: 2},
+		},
+		{
+			"jobs kept apart",
+			[][]metrics.Metric{{ioSample(10, 100, 1), ioSample(20, 7, 1)}},
+			map[int32]uint64{10: 100, 20: 7},
+			map[int32]float64{10: 100, 20: 7},
+			map[int32]int{10: 1, 20: 1},
+		},
+		{
+			"other kinds leave io alone",
+			[][]metrics.Metric{{&metrics.CPUMetric{Pid_id: 11, PPID: 10, CPU: 50, Time: start}}},
+			map[int32]uint64{},
+			map[int32]float64{},
+			map[int32]int{10: 1},
+		},
+		{
+			"unknown kinds only kept in the series",
+			[][]metrics.Metric{{&unknownMetric{job: 10}}},
+			map[int32]uint64{},
+			map[int32]float64{},
+			map[int32]int{10: 1},
+		},
+		{
+			"series bounded by the storage size",
+			[][]metrics.Metric{{ioSample(10, 1, 1), ioSample(10, 1, 2), ioSample(10, 1, 3)}, {ioSample(10, 1, 4), ioSample(10, 1, 5)}},
+			map[int32]uint64{10: 5},
+			map[int32]float64{10: 1},
+			map[int32]int{10: 4},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := newStorage(t, 4)
+			for _, batch := range c.batches {
+				m.AggregateBatch(batch)
+			}
+			summaries, ok := m.GetSummaries(metrics.KindIO)
+			if !ok {
+				t.Fatal("io is not a registered kind")
+			}
+			read := make(map[int32]uint64)
+			peak := make(map[int32]float64)
+			for job, summary := range summaries {
+				read[job] = summary.(metrics.IOSummaryMetric).ReadBytes
+				peak[job] = summary.(metrics.IOSummaryMetric).PeakReadRate
+			}
+			if !reflect.DeepEqual(read, c.read) {
+				t.Errorf("read bytes %v, want %v", read, c.read)
+			}
+			if !reflect.DeepEqual(peak, c.peak) {
+				t.Errorf("peak read rates %v, want %v", peak, c.peak)
+			}
+			for job, count := range c.series {
+				if got := len(m.GetSeries(job)); got != count {
+					t.Errorf("job %d keeps %d samples, want %d", job, got, count)
+				}
+			}
+		})
+	}
+}
+
+func TestGetSummaries(t *testing.T) {
+	m := newStorage(t, 4)
+	if _, ok := m.GetSummaries("bogus"); ok {
+		t.Error("summaries of an unregistered kind")
+	}
+	for _, kind := range metrics.KindNames() {
+		if summaries, ok := m.GetSummaries(kind); !ok || len(summaries) != 0 {
+			t.Errorf("%s: got %v, %v, want no summaries", kind, summaries, ok)
+		}
+	}
+	m.AggregateBatch([]metrics.Metric{ioSample(10, 100, 1)})
+	summaries, _ := m.GetSummaries(metrics.KindIO)
+	summaries[10] = metrics.IOSummaryMetric{}
+	if again, _ := m.GetSummaries(metrics.KindIO); again[10].(metrics.IOSummaryMetric).ReadBytes != 100 {
+		t.Error("changing a snapshot changed the storage")
+	}
+}
+
+func TestStore(t *testing.T) {
+	m := newStorage(t, 4)
+	procChan := make(chan proces.Process)
+	eventChan := make(chan proces.JobEvent)
+	metChan := make(chan []metrics.Metric)
+	stored := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		stored <- m.Store(ctx, procChan, eventChan, metChan)
+	}()
+
+	code := 3
+	procChan <- proces.Process{PGID: 10, Name: "train", StartTime: start}
+	metChan <- []metrics.Metric{ioSample(10, 100, 1)}
+	cancel()
+	metChan <- []metrics.Metric{ioSample(10, 50, 2)}
+	eventChan <- proces.JobEvent{PGID: 10, State: proces.StateFinished, Time: start.Add(time.Minute), ExitCode: &code}
+	eventChan <- proces.JobEvent{PGID: 20, State: proces.StateFinished}
+	close(metChan)
+	close(eventChan)
+	select {
+	case err := <-stored:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Store did not return once its channels were closed")
+	}
+
+	jobs := m.GetJobsSnapshot()
+	if len(jobs) != 1 {
+		t.Fatalf("jobs %v, want only the registered one", jobs)
+	}
+	job := jobs[10]
+	if job.State != proces.StateFinished || job.ExitCode == nil || *job.ExitCode != code || !job.EndTime.Equal(start.Add(time.Minute)) {
+		t.Errorf("job %+v, want finished with exit code %d", job, code)
+	}
+	for _, kind := range metrics.KindNames() {
+		summaries, _ := m.GetSummaries(kind)
+		summary, ok := summaries[10]
+		if !ok {
+			t.Errorf("%s: no summary of the registered job", kind)
+			continue
+		}
+		if summary.JobName() != "train" {
+			t.Errorf("%s: summary of job %q, want train", kind, summary.JobName())
+		}
+	}
+	summaries, _ := m.GetSummaries(metrics.KindIO)
+	if got := summaries[10].(metrics.IOSummaryMetric); got.ReadBytes != 150 || !got.Start.Equal(start) {
+		t.Errorf("io %+v, want 150 bytes read since the start of the job", got)
+	}
+}
+
+func TestSeries(t *testing.T) {
+	cases := []struct {
+		name  string
+		size  int
+		added int
+		kept  []int32
+	}{
+		{"empty", 3, 0, []int32{}},
+		{"not full", 3, 2, []int32{1, 2}},
+		{"full", 3, 3, []int32{1, 2, 3}},
+		{"wrapped", 3, 5, []int32{3, 4, 5}},
+		{"wrapped twice", 2, 5, []int32{4, 5}},
+	}
+	for _, c := range cases {
+		series := NewSeries(c.size)
+		for i := range c.added {
+			series.Add(&unknownMetric{job: int32(i + 1)})
+		}
+		kept := []int32{}
+		for _, sample := range series.Samples() {
+			kept = append(kept, sample.Pid())
+		}
+		if !reflect.DeepEqual(kept, c.kept) {
+			t.Errorf("%s: kept %v, want %v", c.name, kept, c.kept)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package storage
+
+import "github.com/Wesenheit/Skaldenmet/internal/metrics"
+
+// Series keeps the most recent raw samples of a job, the oldest sample is
+// overwritten once the buffer is full.
+type Series struct {
+	samples []metrics.Metric
+	next    int
+	full    bool
+}
+
+func NewSeries(size int) *Series {
+	return &Series{samples: make([]metrics.Metric, size)}
+}
+
+func (s *Series) Add(metric metrics.Metric) {
+	s.samples[s.next] = metric
+	s.next = (s.next + 1) % len(s.samples)
+	s.full = s.full || s.next == 0
+}
+
+// Samples returns the buffered samples from the oldest to the newest
+func (s *Series) Samples() []metrics.Metric {
+	if !s.full {
+		return append([]metrics.Metric{}, s.samples[:s.next]...)
+	}
+	return append(append([]metrics.Metric{}, s.samples[s.next:]...), s.samples[:s.next]...)
+}
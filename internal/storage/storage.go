@@ -8,9 +8,12 @@ import (
 )
 
 type Storage interface {
-	Store(context.Context, chan proces.Process, chan []metrics.Metric) error
+	Store(context.Context, chan proces.Process, chan proces.JobEvent, chan []metrics.Metric) error
 	Close() error
 	Interval() time.Duration
-	GetCPUSnapshot() map[int32]metrics.CPUSummaryMetric
-	GetGPUSnapshot() map[int32]metrics.GPUSummaryMetric
+	SetInterval(time.Duration)
+	GetJobsSnapshot() map[int32]proces.Process
+	GetSummaries(kind string) (map[int32]metrics.Summary, bool)
+	GetProcessSnapshot(job int32) map[int32]metrics.ProcessSummary
+	GetSeries(job int32) []metrics.Metric
 }
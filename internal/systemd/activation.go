@@ -0,0 +1,79 @@
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFdsStart is the first descriptor passed by socket activation
+const listenFdsStart = 3
+
+// Listener is a socket opened by systemd, Name is its FileDescriptorName
+type Listener struct {
+	Name string
+	net.Listener
+}
+
+// Sockets are the listeners passed to the daemon by socket activation
+type Sockets []Listener
+
+// Activated adopts sockets passed by systemd through $LISTEN_FDS. The
+// variables are removed from the environment and the descriptors are not
+// inherited by jobs launched by the daemon.
+func Activated() (Sockets, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(name)
+	}
+
+	sockets := make(Sockets, 0, count)
+	for i := range count {
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			sockets.Close()
+			return nil, fmt.Errorf("socket %d passed by systemd: %w", fd, err)
+		}
+		sockets = append(sockets, Listener{Name: name, Listener: listener})
+	}
+	return sockets, nil
+}
+
+// Take hands over the listener named name, or the one bound to the unix
+// socket at path, and removes it from s. It returns nil if there is none.
+func (s *Sockets) Take(name, path string) net.Listener {
+	for i, listener := range *s {
+		addr := listener.Addr()
+		if listener.Name == name || (path != "" && addr.Network() == "unix" && addr.String() == path) {
+			*s = slices.Delete(*s, i, i+1)
+			return listener.Listener
+		}
+	}
+	return nil
+}
+
+func (s Sockets) Close() {
+	for _, listener := range s {
+		listener.Close()
+	}
+}
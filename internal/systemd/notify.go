@@ -0,0 +1,82 @@
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Notifier reports the state of the daemon to systemd for services of
+// Type=notify. Without $NOTIFY_SOCKET every notification is a no-op.
+type Notifier struct {
+	addr     *net.UnixAddr
+	watchdog time.Duration
+}
+
+// NewNotifier reads $NOTIFY_SOCKET and the watchdog settings. The variables
+// are removed from the environment, jobs launched by the daemon must not
+// notify systemd on its behalf.
+func NewNotifier() *Notifier {
+	n := &Notifier{}
+	if socket := os.Getenv("NOTIFY_SOCKET"); socket != "" {
+		n.addr = &net.UnixAddr{Name: socket, Net: "unixgram"}
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	pid := os.Getenv("WATCHDOG_PID")
+	if err == nil && usec > 0 && (pid == "" || pid == strconv.Itoa(os.Getpid())) {
+		n.watchdog = time.Duration(usec) * time.Microsecond
+	}
+	for _, name := range []string{"NOTIFY_SOCKET", "WATCHDOG_USEC", "WATCHDOG_PID"} {
+		os.Unsetenv(name)
+	}
+	return n
+}
+
+// Send passes raw assignments such as "READY=1" to systemd
+func (n *Notifier) Send(assignments ...string) error {
+	if n.addr == nil {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, n.addr)
+	if err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(strings.Join(assignments, "\n"))); err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return nil
+}
+
+// Ready tells systemd that startup, or a reload, finished
+func (n *Notifier) Ready(status string) error {
+	return n.Send("READY=1", "STATUS="+status)
+}
+
+func (n *Notifier) Stopping() error {
+	return n.Send("STOPPING=1", "STATUS=Shutting down")
+}
+
+// Reloading has to be followed by Ready once the reload finished
+func (n *Notifier) Reloading() error {
+	var now unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &now); err != nil {
+		return err
+	}
+	return n.Send("RELOADING=1", fmt.Sprintf("MONOTONIC_USEC=%d", now.Nano()/1000))
+}
+
+func (n *Notifier) Watchdog() error {
+	return n.Send("WATCHDOG=1")
+}
+
+// WatchdogInterval is how often Watchdog has to be called, half of the
+// timeout configured by WatchdogSec, or zero when the watchdog is disabled.
+func (n *Notifier) WatchdogInterval() time.Duration {
+	return n.watchdog / 2
+}
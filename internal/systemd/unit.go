@@ -0,0 +1,92 @@
+package systemd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ServiceName = "skaldenmet.service"
+	SocketName  = "skaldenmet.socket"
+	// watchdogSec is the watchdog timeout written to generated units
+	watchdogSec = 30
+)
+
+// Unit describes the units written by `met daemon install-unit`
+type Unit struct {
+	Executable string
+	Config     string
+	User       bool
+	// Socket is the path of the control socket when systemd should open it,
+	// empty for a daemon creating its own socket
+	Socket     string
+	SocketMode os.FileMode
+}
+
+// Dir is where units are installed, the system directory or the one of the
+// current user
+func Dir(user bool) (string, error) {
+	if !user {
+		return "/etc/systemd/system", nil
+	}
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(config, "systemd", "user"), nil
+}
+
+// quote escapes an argument of ExecStart, systemd splits command lines like a
+// shell would
+func quote(arg string) string {
+	if !strings.ContainsAny(arg, " \t\"'\\$%") {
+		return arg
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$", "%", "%%").Replace(arg)
+	return `"` + escaped + `"`
+}
+
+func (u Unit) Service() string {
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=Skaldenmet job monitoring daemon\n")
+	if u.Socket != "" {
+		fmt.Fprintf(&b, "Requires=%s\nAfter=%s\n", SocketName, SocketName)
+	}
+
+	b.WriteString("\n[Service]\n")
+	b.WriteString("Type=notify\n")
+	fmt.Fprintf(&b, "ExecStart=%s daemon --config %s\n", quote(u.Executable), quote(u.Config))
+	b.WriteString("ExecReload=/bin/kill -HUP $MAINPID\n")
+	fmt.Fprintf(&b, "WatchdogSec=%d\n", watchdogSec)
+	b.WriteString("Restart=on-failure\n")
+
+	b.WriteString("\n[Install]\n")
+	if u.User {
+		b.WriteString("WantedBy=default.target\n")
+	} else {
+		b.WriteString("WantedBy=multi-user.target\n")
+	}
+	if u.Socket != "" {
+		fmt.Fprintf(&b, "Also=%s\n", SocketName)
+	}
+	return b.String()
+}
+
+// SocketUnit opens the control socket on behalf of the daemon, clients
+// connecting before the daemon is up wait instead of failing.
+func (u Unit) SocketUnit() string {
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=Skaldenmet control socket\n")
+	b.WriteString("\n[Socket]\n")
+	fmt.Fprintf(&b, "ListenStream=%s\n", u.Socket)
+	b.WriteString("FileDescriptorName=control\n")
+	fmt.Fprintf(&b, "SocketMode=%04o\n", u.SocketMode)
+	b.WriteString("RemoveOnStop=yes\n")
+	b.WriteString("\n[Install]\n")
+	b.WriteString("WantedBy=sockets.target\n")
+	return b.String()
+}